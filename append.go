@@ -0,0 +1,45 @@
+package avro
+
+import (
+	"avro/zigzag"
+	"encoding/binary"
+	"math"
+)
+
+// The Append functions append the avro binary encoding of a primitive
+// value to dst and return the extended buffer.
+
+func AppendBool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, 1)
+	}
+	return append(dst, 0)
+}
+
+func AppendInt(dst []byte, v int32) []byte {
+	return AppendLong(dst, int64(v))
+}
+
+func AppendLong(dst []byte, v int64) []byte {
+	return binary.AppendUvarint(dst, zigzag.Encode(v))
+}
+
+// AppendFloat appends v as 4 bytes in little endian.
+func AppendFloat(dst []byte, v float32) []byte {
+	return binary.LittleEndian.AppendUint32(dst, math.Float32bits(v))
+}
+
+// AppendDouble appends v as 8 bytes in little endian.
+func AppendDouble(dst []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(dst, math.Float64bits(v))
+}
+
+func AppendBytes(dst []byte, b []byte) []byte {
+	dst = AppendLong(dst, int64(len(b)))
+	return append(dst, b...)
+}
+
+func AppendString(dst []byte, s string) []byte {
+	dst = AppendLong(dst, int64(len(s)))
+	return append(dst, s...)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"avro"
+	"avro/ipc"
+	"avro/random"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schemaFlags adds the -schema and -schema-file flags to fs.
+func schemaFlags(fs *flag.FlagSet) func() (*avro.Schema, error) {
+	text := fs.String("schema", "", "schema as JSON")
+	file := fs.String("schema-file", "", "file holding the schema")
+	return func() (*avro.Schema, error) {
+		switch {
+		case *text != "" && *file != "":
+			return nil, fmt.Errorf("both -schema and -schema-file given")
+		case *text != "":
+			return avro.ParseSchema([]byte(*text))
+		case *file != "":
+			b, err := os.ReadFile(*file)
+			if err != nil {
+				return nil, err
+			}
+			return avro.ParseSchema(b)
+		}
+		return nil, nil
+	}
+}
+
+func openFile(name string) (*avro.FileReader, io.Closer, error) {
+	f, err := open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fr, err := avro.NewFileReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s:%s", name, err)
+	}
+	return fr, f, nil
+}
+
+func runCat(args []string) error {
+	fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+	offset := fs.Int("offset", 0, "number of records to skip")
+	limit := fs.Int("limit", -1, "maximum number of records to output, -1 for all")
+	rate := fs.Float64("samplerate", 1, "fraction of records to output")
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	if *rate <= 0 || *rate > 1 {
+		return fmt.Errorf("samplerate must be in (0, 1]")
+	}
+	inputs := fs.Args()[:fs.NArg()-1]
+
+	var (
+		out   *output
+		fw    *avro.FileWriter
+		n     int // records read
+		taken int // records written
+	)
+	for _, name := range inputs {
+		fr, f, err := openFile(name)
+		if err != nil {
+			return err
+		}
+		if fw == nil {
+			out, err = create(fs.Arg(fs.NArg() - 1))
+			if err != nil {
+				f.Close()
+				return err
+			}
+			defer out.Close()
+			fw, err = avro.NewFileWriter(out, fr.Schema(), fr.Codec())
+			if err != nil {
+				f.Close()
+				return err
+			}
+		} else if fr.Schema().String() != fw.Schema().String() {
+			f.Close()
+			return fmt.Errorf("%s has a different schema", name)
+		}
+		for *limit < 0 || taken < *limit {
+			v, err := fr.ReadGeneric()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return err
+			}
+			i := n - *offset
+			n++
+			// take a record each time the sampled count goes up
+			if i < 0 || int(float64(i+1)**rate) == int(float64(i)**rate) {
+				continue
+			}
+			if err := fw.WriteGeneric(v); err != nil {
+				f.Close()
+				return err
+			}
+			taken++
+		}
+		f.Close()
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func runToJSON(args []string) error {
+	fs := flag.NewFlagSet("tojson", flag.ContinueOnError)
+	pretty := fs.Bool("pretty", false, "indent the output")
+	schema := schemaFlags(fs)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	s, err := schema()
+	if err != nil {
+		return err
+	}
+	f, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out, err := create("-")
+	if err != nil {
+		return err
+	}
+
+	var next func() (interface{}, error)
+	if s != nil {
+		// raw datums
+		r := bufio.NewReader(f)
+		d := avro.NewDecoder(r)
+		next = func() (interface{}, error) {
+			if _, err := r.Peek(1); err != nil {
+				return nil, err
+			}
+			return d.ReadGeneric(s)
+		}
+	} else {
+		fr, err := avro.NewFileReader(f)
+		if err != nil {
+			return err
+		}
+		s = fr.Schema()
+		next = fr.ReadGeneric
+	}
+	for {
+		v, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		b, err := avro.ToJSON(s, v)
+		if err != nil {
+			return err
+		}
+		if *pretty {
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, b, "", "  "); err != nil {
+				return err
+			}
+			b = buf.Bytes()
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+		if err := out.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func runFromJSON(args []string) error {
+	fs := flag.NewFlagSet("fromjson", flag.ContinueOnError)
+	codec := fs.String("codec", avro.CodecNull, "compression codec, null or deflate")
+	raw := fs.Bool("raw", false, "write raw datums instead of a container file")
+	schema := schemaFlags(fs)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	s, err := schema()
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("-schema or -schema-file required")
+	}
+	f, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out, err := create("-")
+	if err != nil {
+		return err
+	}
+
+	write := func(v interface{}) error {
+		e := avro.NewEncoder(out)
+		if err := e.WriteGeneric(s, v); err != nil {
+			return err
+		}
+		return e.Flush()
+	}
+	var fw *avro.FileWriter
+	if !*raw {
+		fw, err = avro.NewFileWriter(out, s, *codec)
+		if err != nil {
+			return err
+		}
+		write = fw.WriteGeneric
+	}
+
+	dec := json.NewDecoder(f)
+	for {
+		var m json.RawMessage
+		err := dec.Decode(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		v, err := avro.FromJSON(s, m)
+		if err != nil {
+			return err
+		}
+		if err := write(v); err != nil {
+			return err
+		}
+	}
+	if fw != nil {
+		if err := fw.Close(); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func runGetSchema(args []string) error {
+	fs := flag.NewFlagSet("getschema", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	fr, f, err := openFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := json.MarshalIndent(fr.Schema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", b)
+	return err
+}
+
+func runGetMeta(args []string) error {
+	fs := flag.NewFlagSet("getmeta", flag.ContinueOnError)
+	key := fs.String("key", "", "print only the value of this key")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	fr, f, err := openFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	meta := fr.Meta()
+	if *key != "" {
+		v, ok := meta[*key]
+		if !ok {
+			return fmt.Errorf("no metadata %s", *key)
+		}
+		_, err = fmt.Fprintf(stdout, "%s\n", v)
+		return err
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(stdout, "%s\t%s\n", k, meta[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runCount(args []string) error {
+	fs := flag.NewFlagSet("count", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	fr, f, err := openFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var n int64
+	for {
+		count, _, err := fr.ReadBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n += int64(count)
+	}
+	_, err = fmt.Fprintln(stdout, n)
+	return err
+}
+
+func runConcat(args []string) error {
+	fs := flag.NewFlagSet("concat", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	inputs := fs.Args()[:fs.NArg()-1]
+
+	var (
+		out *output
+		fw  *avro.FileWriter
+	)
+	for _, name := range inputs {
+		fr, f, err := openFile(name)
+		if err != nil {
+			return err
+		}
+		if fw == nil {
+			out, err = create(fs.Arg(fs.NArg() - 1))
+			if err != nil {
+				f.Close()
+				return err
+			}
+			defer out.Close()
+			fw, err = avro.NewFileWriter(out, fr.Schema(), fr.Codec())
+			if err != nil {
+				f.Close()
+				return err
+			}
+			for k, v := range fr.Meta() {
+				if !strings.HasPrefix(k, "avro.") {
+					fw.SetMeta(k, v)
+				}
+			}
+		} else if fr.Schema().String() != fw.Schema().String() {
+			f.Close()
+			return fmt.Errorf("%s has a different schema", name)
+		}
+		for {
+			count, data, err := fr.ReadBlock()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return err
+			}
+			if err := fw.WriteBlock(count, data); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func runRandom(args []string) error {
+	fs := flag.NewFlagSet("random", flag.ContinueOnError)
+	count := fs.Int("count", 0, "number of records to generate")
+	seed := fs.Int64("seed", 0, "seed of the generator, 0 for a random seed")
+	codec := fs.String("codec", avro.CodecNull, "compression codec, null or deflate")
+	maxLength := fs.Int("max-length", random.DefaultMaxLength, "maximum length of strings, bytes, arrays and maps")
+	schema := schemaFlags(fs)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	s, err := schema()
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("-schema or -schema-file required")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	g := random.New(rand.New(rand.NewSource(*seed)))
+	g.MaxLength = *maxLength
+
+	out, err := create(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	fw, err := avro.NewFileWriter(out, s, *codec)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < *count; i++ {
+		if err := fw.WriteGeneric(g.Generate(s)); err != nil {
+			return err
+		}
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	if out.f != nil {
+		fmt.Fprintf(os.Stderr, "seed %d\n", *seed)
+	}
+	return out.Close()
+}
+
+func runCall(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ContinueOnError)
+	protoFile := fs.String("proto", "", "protocol file (.avpr)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the call")
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	if *protoFile == "" {
+		return fmt.Errorf("no -proto")
+	}
+	b, err := os.ReadFile(*protoFile)
+	if err != nil {
+		return err
+	}
+	p, err := ipc.ParseProtocol(b)
+	if err != nil {
+		return err
+	}
+	m := p.Messages[fs.Arg(1)]
+	if m == nil {
+		return fmt.Errorf("unknown message:%s", fs.Arg(1))
+	}
+	params := map[string]interface{}{}
+	if fs.NArg() > 2 {
+		v, err := avro.FromJSON(m.Request, []byte(fs.Arg(2)))
+		if err != nil {
+			return fmt.Errorf("parameters:%s", err)
+		}
+		params = v.(map[string]interface{})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c := ipc.NewClient(conn, p)
+	defer c.Close()
+	resp, err := c.CallGeneric(ctx, m.Name, params)
+	if err != nil {
+		return err
+	}
+	if m.OneWay {
+		return nil
+	}
+	out, err := avro.ToJSON(m.Response, resp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", out)
+	return err
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"avro"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var pointSchema = avro.MustParseSchema(`{"type": "record", "name": "point", "fields": [
+        {"name": "name", "type": "string"},
+        {"name": "x", "type": ["int", "long"]}
+]}`)
+
+var points = []interface{}{
+	map[string]interface{}{"name": "a", "x": int32(1)},
+	map[string]interface{}{"name": "b", "x": int64(2)},
+	map[string]interface{}{"name": "c", "x": int32(3)},
+}
+
+const pointsJSON = `{"name":"a","x":{"int":1}}
+{"name":"b","x":{"long":2}}
+{"name":"c","x":{"int":3}}
+`
+
+// writePoints writes points to a container file in dir.
+func writePoints(t *testing.T, dir string) string {
+	name := filepath.Join(dir, "points.avro")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fw, err := avro.NewFileWriter(f, pointSchema, avro.CodecDeflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.SetMeta("origin", []byte("test"))
+	for _, v := range points {
+		if err := fw.WriteGeneric(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// readPoints returns the records of the container file b.
+func readPoints(t *testing.T, b []byte) []interface{} {
+	fr, err := avro.NewFileReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Schema().String() != pointSchema.String() {
+		t.Error(fr.Schema())
+	}
+	var vs []interface{}
+	for {
+		v, err := fr.ReadGeneric()
+		if err == io.EOF {
+			return vs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs = append(vs, v)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	input := writePoints(t, dir)
+	jsonInput := filepath.Join(dir, "points.json")
+	if err := os.WriteFile(jsonInput, []byte(pointsJSON), 0666); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.avro")
+
+	tests := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, out []byte)
+	}{
+		{"cat", []string{"-offset", "1", "-limit", "1", input, output}, func(t *testing.T, out []byte) {
+			b, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vs := readPoints(t, b); !reflect.DeepEqual(vs, points[1:2]) {
+				t.Error(vs)
+			}
+		}},
+		{"cat", []string{input, input, "-"}, func(t *testing.T, out []byte) {
+			if vs := readPoints(t, out); !reflect.DeepEqual(vs, append(points, points...)) {
+				t.Error(vs)
+			}
+		}},
+		{"tojson", []string{input}, func(t *testing.T, out []byte) {
+			if string(out) != pointsJSON {
+				t.Errorf("%s", out)
+			}
+		}},
+		{"tojson", []string{"-pretty", input}, func(t *testing.T, out []byte) {
+			if !strings.HasPrefix(string(out), "{\n  \"name\": \"a\",\n  \"x\": {\n    \"int\": 1\n  }\n}\n") {
+				t.Errorf("%s", out)
+			}
+		}},
+		{"fromjson", []string{"-schema", pointSchema.String(), jsonInput}, func(t *testing.T, out []byte) {
+			if vs := readPoints(t, out); !reflect.DeepEqual(vs, points) {
+				t.Error(vs)
+			}
+		}},
+		{"getschema", []string{input}, func(t *testing.T, out []byte) {
+			s, err := avro.ParseSchema(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.String() != pointSchema.String() {
+				t.Errorf("%s", out)
+			}
+		}},
+		{"getmeta", []string{input}, func(t *testing.T, out []byte) {
+			want := "avro.codec\tdeflate\navro.schema\t" + pointSchema.String() + "\norigin\ttest\n"
+			if string(out) != want {
+				t.Errorf("%s", out)
+			}
+		}},
+		{"getmeta", []string{"-key", "origin", input}, func(t *testing.T, out []byte) {
+			if string(out) != "test\n" {
+				t.Errorf("%s", out)
+			}
+		}},
+		{"count", []string{input}, func(t *testing.T, out []byte) {
+			if string(out) != "3\n" {
+				t.Errorf("%s", out)
+			}
+		}},
+		{"concat", []string{input, input, "-"}, func(t *testing.T, out []byte) {
+			fr, err := avro.NewFileReader(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fr.Codec() != avro.CodecDeflate || string(fr.Meta()["origin"]) != "test" {
+				t.Error(fr.Codec(), fr.Meta())
+			}
+			if vs := readPoints(t, out); !reflect.DeepEqual(vs, append(points, points...)) {
+				t.Error(vs)
+			}
+		}},
+		{"random", []string{"-count", "5", "-seed", "1", "-schema", pointSchema.String(), output}, func(t *testing.T, out []byte) {
+			b, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vs := readPoints(t, b); len(vs) != 5 {
+				t.Error(vs)
+			}
+		}},
+	}
+	for _, test := range tests {
+		out, err := run(test.name, test.args...)
+		if err != nil {
+			t.Errorf("%s %v:%s", test.name, test.args, err)
+			continue
+		}
+		test.check(t, out)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	dir := t.TempDir()
+	notAvro := filepath.Join(dir, "points.json")
+	if err := os.WriteFile(notAvro, []byte(pointsJSON), 0666); err != nil {
+		t.Fatal(err)
+	}
+	input := writePoints(t, dir)
+	ints := filepath.Join(dir, "ints.avro")
+	if _, err := run("random", "-count", "1", "-schema", `"int"`, ints); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"cat", []string{notAvro, "-"}},
+		{"cat", []string{"-samplerate", "2", notAvro, "-"}},
+		{"tojson", []string{notAvro}},
+		{"tojson", []string{filepath.Join(dir, "missing.avro")}},
+		{"fromjson", []string{notAvro}},
+		{"fromjson", []string{"-schema", `"int"`, notAvro}},
+		{"getschema", []string{notAvro}},
+		{"getmeta", []string{"-key", "missing", input}},
+		{"count", []string{notAvro}},
+		{"concat", []string{input, ints, "-"}},
+		{"concat", []string{input}},
+		{"random", []string{filepath.Join(dir, "random.avro")}},
+	}
+	for _, test := range tests {
+		if _, err := run(test.name, test.args...); err == nil {
+			t.Errorf("%s %v: no error", test.name, test.args)
+		}
+	}
+}
+
+// run runs the command name, returning what it wrote to stdout.
+func run(name string, args ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	stdout = &buf
+	defer func() { stdout = os.Stdout }()
+	err := commands[name].run(args)
+	return buf.Bytes(), err
+}
+
+func TestCatUnions(t *testing.T) {
+	s := avro.MustParseSchema(`{"type": "record", "name": "u", "fields": [
+                {"name": "m", "type": [{"type": "map", "values": "string"},
+                        {"type": "record", "name": "R", "fields": [{"name": "a", "type": "string"}]}]},
+                {"name": "f", "type": ["bytes", {"type": "fixed", "name": "F", "size": 2}]},
+                {"name": "e", "type": ["string", {"type": "enum", "name": "E", "symbols": ["a", "b"]}]}
+        ]}`)
+	values := []interface{}{
+		map[string]interface{}{
+			"m": avro.Branch{Name: "R", Value: map[string]interface{}{"a": "x"}},
+			"f": avro.Branch{Name: "F", Value: []byte{1, 2}},
+			"e": avro.Branch{Name: "E", Value: "b"},
+		},
+		map[string]interface{}{
+			"m": map[string]interface{}{"a": "x"},
+			"f": []byte{1, 2},
+			"e": "b",
+		},
+	}
+	var buf bytes.Buffer
+	fw, err := avro.NewFileWriter(&buf, s, avro.CodecNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range values {
+		if err := fw.WriteGeneric(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "unions.avro")
+	if err := os.WriteFile(input, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := run("cat", input, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := func(b []byte) []byte {
+		fr, err := avro.NewFileReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := fr.ReadBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if in, out := block(buf.Bytes()), block(out); !bytes.Equal(in, out) {
+		t.Errorf("%x written as %x", in, out)
+	}
+
+	out, err = run("tojson", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"m":{"R":{"a":"x"}},"f":{"F":"\u0001\u0002"},"e":{"E":"b"}}
+{"m":{"map":{"a":"x"}},"f":{"bytes":"\u0001\u0002"},"e":{"string":"b"}}
+`
+	if string(out) != expect {
+		t.Errorf("%s", out)
+	}
+}
@@ -0,0 +1,116 @@
+// Command avro reads and writes avro container files and datums. Its
+// subcommands follow those of the Java avro-tools:
+//
+//	avro cat [-offset n] [-limit n] [-samplerate r] input... output
+//	avro tojson [-pretty] [-schema json | -schema-file file] input
+//	avro fromjson [-schema json | -schema-file file] [-codec c] [-raw] input
+//	avro getschema input
+//	avro getmeta [-key k] input
+//	avro count input
+//	avro concat input... output
+//	avro random [-count n] [-seed s] [-codec c] [-max-length n] -schema json | -schema-file file output
+//	avro call [-timeout d] -proto file addr message [params]
+//
+// A file named - is the standard input or output.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"cat":       {runCat, "extract samples from files"},
+	"tojson":    {runToJSON, "dump a container file, or raw datums with -schema, as JSON"},
+	"fromjson":  {runFromJSON, "read JSON records and write a container file or raw datums"},
+	"getschema": {runGetSchema, "print the schema of a container file"},
+	"getmeta":   {runGetMeta, "print the metadata of a container file"},
+	"count":     {runCount, "count the records of a container file"},
+	"concat":    {runConcat, "concatenate container files with the same schema"},
+	"random":    {runRandom, "write a container file of random data"},
+	"call":      {runCall, "call a message of an ipc server, with JSON parameters"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "avro %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: avro <command> [flags] args...")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	os.Exit(2)
+}
+
+func open(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
+
+// stdout is the output file named -.
+var stdout io.Writer = os.Stdout
+
+// output is a buffered output file.
+type output struct {
+	*bufio.Writer
+	f *os.File // nil for stdout
+}
+
+func create(name string) (*output, error) {
+	if name == "-" {
+		return &output{bufio.NewWriter(stdout), nil}, nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &output{bufio.NewWriter(f), f}, nil
+}
+
+func (o *output) Close() error {
+	err := o.Flush()
+	if o.f != nil {
+		if cerr := o.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func parseFlags(fs *flag.FlagSet, args []string, minArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < minArgs {
+		fs.Usage()
+		return fmt.Errorf("expected at least %d arguments", minArgs)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package avro
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// IncompatibilityKind classifies why a reader schema can not read data
+// written with a writer schema.
+type IncompatibilityKind string
+
+const (
+	NameMismatch            IncompatibilityKind = "NAME_MISMATCH"
+	FixedSizeMismatch       IncompatibilityKind = "FIXED_SIZE_MISMATCH"
+	MissingEnumSymbols      IncompatibilityKind = "MISSING_ENUM_SYMBOLS"
+	ReaderFieldMissingValue IncompatibilityKind = "READER_FIELD_MISSING_DEFAULT_VALUE"
+	TypeMismatch            IncompatibilityKind = "TYPE_MISMATCH"
+	MissingUnionBranch      IncompatibilityKind = "MISSING_UNION_BRANCH"
+)
+
+// Incompatibility is a reason a reader schema can not read data written
+// with a writer schema.
+type Incompatibility struct {
+	Kind IncompatibilityKind
+	// Path is the location in the reader schema, e.g. /fields/0/type.
+	Path    string
+	Message string
+
+	// Version is the index in the history passed to
+	// CheckCompatibilityMode of the schema that was checked against,
+	// and Forward is set if the new schema was the writer.
+	Version int
+	Forward bool
+}
+
+func (i Incompatibility) Error() string {
+	return fmt.Sprintf("%s at %s:%s", i.Kind, i.Path, i.Message)
+}
+
+// CheckCompatibility returns every reason data written with writer can
+// not be read with reader, following the schema resolution rules of the
+// specification. The schemas are compatible if none is returned.
+func CheckCompatibility(reader, writer *Schema) []Incompatibility {
+	c := &compatChecker{seen: make(map[[2]*Schema]bool)}
+	c.check(reader, writer, "")
+	return c.errs
+}
+
+// CompatibilityMode is a compatibility level of a schema registry.
+type CompatibilityMode int
+
+const (
+	// Backward checks the new schema can read data written with the
+	// latest schema, Forward that the latest schema can read data written
+	// with the new schema, and Full checks both.
+	Backward CompatibilityMode = iota
+	Forward
+	Full
+	// The transitive modes check against every schema in the history
+	// instead of the latest only.
+	BackwardTransitive
+	ForwardTransitive
+	FullTransitive
+)
+
+func (m CompatibilityMode) String() string {
+	switch m {
+	case Backward:
+		return "BACKWARD"
+	case Forward:
+		return "FORWARD"
+	case Full:
+		return "FULL"
+	case BackwardTransitive:
+		return "BACKWARD_TRANSITIVE"
+	case ForwardTransitive:
+		return "FORWARD_TRANSITIVE"
+	case FullTransitive:
+		return "FULL_TRANSITIVE"
+	}
+	return "CompatibilityMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// CheckCompatibilityMode checks schema against history, ordered from the
+// oldest schema to the latest, like a schema registry does with mode.
+func CheckCompatibilityMode(mode CompatibilityMode, schema *Schema, history []*Schema) []Incompatibility {
+	if len(history) == 0 {
+		return nil
+	}
+	first := len(history) - 1
+	backward, forward := false, false
+	switch mode {
+	case Backward, Forward, Full:
+	case BackwardTransitive, ForwardTransitive, FullTransitive:
+		first = 0
+	default:
+		panic(fmt.Errorf("unknown compatibility mode:%d", mode))
+	}
+	switch mode {
+	case Backward, BackwardTransitive:
+		backward = true
+	case Forward, ForwardTransitive:
+		forward = true
+	default:
+		backward, forward = true, true
+	}
+
+	var errs []Incompatibility
+	for i := first; i < len(history); i++ {
+		if backward {
+			for _, err := range CheckCompatibility(schema, history[i]) {
+				err.Version = i
+				errs = append(errs, err)
+			}
+		}
+		if forward {
+			for _, err := range CheckCompatibility(history[i], schema) {
+				err.Version = i
+				err.Forward = true
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+type compatChecker struct {
+	// pairs of named types being checked, assumed compatible when met
+	// again to stop recursion
+	seen map[[2]*Schema]bool
+	errs []Incompatibility
+}
+
+func (c *compatChecker) report(kind IncompatibilityKind, path, format string, args ...interface{}) {
+	if path == "" {
+		path = "/"
+	}
+	c.errs = append(c.errs, Incompatibility{
+		Kind:    kind,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// compatible reports whether reader can read writer without recording
+// the reasons.
+func (c *compatChecker) compatible(reader, writer *Schema) bool {
+	// pairs first met here may still be incompatible elsewhere
+	seen := make(map[[2]*Schema]bool, len(c.seen))
+	for k := range c.seen {
+		seen[k] = true
+	}
+	sub := &compatChecker{seen: seen}
+	sub.check(reader, writer, "")
+	return len(sub.errs) == 0
+}
+
+func (c *compatChecker) check(reader, writer *Schema, path string) {
+	if reader.isNamed() && writer.isNamed() {
+		pair := [2]*Schema{reader, writer}
+		if c.seen[pair] {
+			return
+		}
+		c.seen[pair] = true
+	}
+
+	if writer.Type == TypeUnion {
+		// every branch the writer may use must be readable
+		for i, b := range writer.Branches {
+			if reader.Type == TypeUnion {
+				if !c.readableBranch(reader, b) {
+					c.report(MissingUnionBranch, path, "reader union lacks type %s of writer union branch %d", typeName(b), i)
+				}
+			} else {
+				c.check(reader, b, path)
+			}
+		}
+		return
+	}
+	if reader.Type == TypeUnion {
+		if !c.readableBranch(reader, writer) {
+			c.report(MissingUnionBranch, path, "reader union lacks type %s", typeName(writer))
+		}
+		return
+	}
+
+	if reader.Type != writer.Type {
+		if !promotable(writer.Type, reader.Type) {
+			c.report(TypeMismatch, path, "reader type %s not compatible with writer type %s", reader.Type, writer.Type)
+		}
+		return
+	}
+
+	switch reader.Type {
+	case TypeRecord:
+		if !nameMatches(reader, writer) {
+			c.report(NameMismatch, path+"/name", "expected %s, found %s", writer.Name, reader.Name)
+			return
+		}
+		for i, f := range reader.Fields {
+			fpath := path + "/fields/" + strconv.Itoa(i)
+			wf := writerField(writer, f)
+			if wf == nil {
+				if !f.HasDefault {
+					c.report(ReaderFieldMissingValue, fpath, "field %s has no default value", f.Name)
+				}
+				continue
+			}
+			c.check(f.Type, wf.Type, fpath+"/type")
+		}
+	case TypeEnum:
+		if !nameMatches(reader, writer) {
+			c.report(NameMismatch, path+"/name", "expected %s, found %s", writer.Name, reader.Name)
+			return
+		}
+		if reader.EnumDefault != "" {
+			return
+		}
+		var missing []string
+		for _, sym := range writer.Symbols {
+			if enumIndex(reader.Symbols, sym) == -1 {
+				missing = append(missing, sym)
+			}
+		}
+		if len(missing) > 0 {
+			c.report(MissingEnumSymbols, path+"/symbols", "reader enum lacks symbols %v", missing)
+		}
+	case TypeFixed:
+		if !nameMatches(reader, writer) {
+			c.report(NameMismatch, path+"/name", "expected %s, found %s", writer.Name, reader.Name)
+			return
+		}
+		if reader.Size != writer.Size {
+			c.report(FixedSizeMismatch, path+"/size", "expected %d, found %d", writer.Size, reader.Size)
+		}
+	case TypeArray:
+		c.check(reader.Items, writer.Items, path+"/items")
+	case TypeMap:
+		c.check(reader.Values, writer.Values, path+"/values")
+	}
+}
+
+func (c *compatChecker) readableBranch(reader, writer *Schema) bool {
+	for _, b := range reader.Branches {
+		if c.compatible(b, writer) {
+			return true
+		}
+	}
+	return false
+}
+
+// promotable reports whether a writer type can be read as a reader type.
+func promotable(writer, reader Type) bool {
+	switch writer {
+	case TypeInt:
+		return reader == TypeLong || reader == TypeFloat || reader == TypeDouble
+	case TypeLong:
+		return reader == TypeFloat || reader == TypeDouble
+	case TypeFloat:
+		return reader == TypeDouble
+	case TypeString:
+		return reader == TypeBytes
+	case TypeBytes:
+		return reader == TypeString
+	}
+	return false
+}
+
+// nameMatches compares the unqualified names of named types, or the
+// reader aliases with the writer full name.
+func nameMatches(reader, writer *Schema) bool {
+	if reader.SimpleName() == writer.SimpleName() {
+		return true
+	}
+	for _, a := range reader.Aliases {
+		if a == writer.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// writerField returns the writer field read by the reader field f.
+func writerField(writer *Schema, f *Field) *Field {
+	if wf, _ := writer.FieldByName(f.Name); wf != nil {
+		return wf
+	}
+	for _, a := range f.Aliases {
+		if wf, _ := writer.FieldByName(a); wf != nil {
+			return wf
+		}
+	}
+	return nil
+}
+
+func typeName(s *Schema) string {
+	if s.isNamed() {
+		return s.Name
+	}
+	return string(s.Type)
+}
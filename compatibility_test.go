@@ -0,0 +1,80 @@
+package avro
+
+import (
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	cases := []struct {
+		reader, writer string
+		kinds          []IncompatibilityKind
+		path           string
+	}{
+		{`"long"`, `"int"`, nil, ""},
+		{`"int"`, `"long"`, []IncompatibilityKind{TypeMismatch}, "/"},
+		{`"bytes"`, `"string"`, nil, ""},
+		{`["null", "long"]`, `"int"`, nil, ""},
+		{`["null", "string"]`, `["null", "int"]`, []IncompatibilityKind{MissingUnionBranch}, "/"},
+		{`"long"`, `["int", "long"]`, nil, ""},
+		{`{"type": "array", "items": "int"}`, `{"type": "array", "items": "string"}`, []IncompatibilityKind{TypeMismatch}, "/items"},
+		{`{"type": "enum", "name": "E", "symbols": ["A"]}`, `{"type": "enum", "name": "E", "symbols": ["A", "B"]}`, []IncompatibilityKind{MissingEnumSymbols}, "/symbols"},
+		{`{"type": "enum", "name": "E", "symbols": ["A"], "default": "A"}`, `{"type": "enum", "name": "E", "symbols": ["A", "B"]}`, nil, ""},
+		{`{"type": "fixed", "name": "F", "size": 2}`, `{"type": "fixed", "name": "F", "size": 4}`, []IncompatibilityKind{FixedSizeMismatch}, "/size"},
+		{`{"type": "fixed", "name": "F", "size": 2}`, `{"type": "fixed", "name": "G", "size": 2}`, []IncompatibilityKind{NameMismatch}, "/name"},
+		{`{"type": "fixed", "name": "a.F", "aliases": ["G"], "size": 2}`, `{"type": "fixed", "name": "a.G", "size": 2}`, nil, ""},
+		{
+			`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "int"}, {"name": "b", "type": "int"}, {"name": "c", "type": "int", "default": 0}]}`,
+			`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "string"}, {"name": "d", "type": "int"}]}`,
+			[]IncompatibilityKind{TypeMismatch, ReaderFieldMissingValue},
+			"/fields/0/type",
+		},
+		{
+			`{"type": "record", "name": "R", "fields": [{"name": "b", "aliases": ["a"], "type": "long"}]}`,
+			`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "int"}]}`,
+			nil, "",
+		},
+		{
+			`{"type": "record", "name": "L", "fields": [{"name": "v", "type": "long"}, {"name": "next", "type": ["null", "L"]}]}`,
+			`{"type": "record", "name": "L", "fields": [{"name": "v", "type": "int"}, {"name": "next", "type": ["null", "L"]}]}`,
+			nil, "",
+		},
+	}
+	for _, c := range cases {
+		errs := CheckCompatibility(MustParseSchema(c.reader), MustParseSchema(c.writer))
+		if len(errs) != len(c.kinds) {
+			t.Errorf("%s <- %s:%v", c.reader, c.writer, errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Kind != c.kinds[i] {
+				t.Errorf("%s <- %s:%v", c.reader, c.writer, errs)
+			}
+		}
+		if len(errs) > 0 && errs[0].Path != c.path {
+			t.Errorf("%s <- %s:%s", c.reader, c.writer, errs[0].Path)
+		}
+	}
+}
+
+func TestCheckCompatibilityMode(t *testing.T) {
+	v1 := MustParseSchema(`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "int"}]}`)
+	v2 := MustParseSchema(`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "int"}, {"name": "b", "type": "int", "default": 0}]}`)
+	v3 := MustParseSchema(`{"type": "record", "name": "R", "fields": [{"name": "b", "type": "int", "default": 0}]}`)
+	history := []*Schema{v1, v2}
+
+	if errs := CheckCompatibilityMode(Full, v3, history); len(errs) != 1 || !errs[0].Forward || errs[0].Version != 1 {
+		t.Error(errs)
+	}
+	if errs := CheckCompatibilityMode(Backward, v3, history); len(errs) != 0 {
+		t.Error(errs)
+	}
+
+	v4 := MustParseSchema(`{"type": "record", "name": "R", "fields": [{"name": "b", "type": "int"}]}`)
+	if errs := CheckCompatibilityMode(Backward, v4, history); len(errs) != 0 {
+		t.Error(errs)
+	}
+	errs := CheckCompatibilityMode(BackwardTransitive, v4, history)
+	if len(errs) != 1 || errs[0].Version != 0 || errs[0].Kind != ReaderFieldMissingValue {
+		t.Error(errs)
+	}
+}
@@ -0,0 +1,344 @@
+package avro
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Codecs of the blocks of a container file.
+const (
+	CodecNull    = "null"
+	CodecDeflate = "deflate"
+)
+
+const (
+	containerMagic = "Obj\x01"
+	syncSize       = 16
+
+	// blockSize is the size of encoded data a FileWriter buffers before
+	// writing a block.
+	blockSize = 64000
+)
+
+// DefaultMaxBlockSize is the size of the largest block a FileReader
+// reads, once decompressed.
+const DefaultMaxBlockSize = 64 << 20
+
+// FileWriter writes an object container file: a header with the schema
+// and metadata, followed by blocks of encoded values.
+type FileWriter struct {
+	w      io.Writer
+	schema *Schema
+	codec  string
+	meta   map[string][]byte
+	sync   [syncSize]byte
+
+	enc         *Encoder
+	count       int
+	wroteHeader bool
+}
+
+// NewFileWriter returns a FileWriter writing values of schema s to w,
+// compressing blocks with codec, which is CodecNull or CodecDeflate.
+func NewFileWriter(w io.Writer, s *Schema, codec string) (*FileWriter, error) {
+	if codec == "" {
+		codec = CodecNull
+	}
+	if codec != CodecNull && codec != CodecDeflate {
+		return nil, fmt.Errorf("not supported codec:%s", codec)
+	}
+	fw := &FileWriter{
+		w:      w,
+		schema: s,
+		codec:  codec,
+		meta:   make(map[string][]byte),
+		enc:    NewEncoder(nil),
+	}
+	if _, err := rand.Read(fw.sync[:]); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// Schema returns the schema of the file.
+func (fw *FileWriter) Schema() *Schema {
+	return fw.schema
+}
+
+// SetMeta sets a metadata entry of the header. It must be called before
+// the first value is written.
+func (fw *FileWriter) SetMeta(key string, value []byte) {
+	fw.meta[key] = value
+}
+
+// Encode writes x, which must be of the schema of the file.
+func (fw *FileWriter) Encode(x interface{}) error {
+	n := len(fw.enc.buf)
+	if err := fw.enc.WriteValue(x); err != nil {
+		fw.enc.buf = fw.enc.buf[:n]
+		return err
+	}
+	return fw.appended()
+}
+
+// WriteGeneric writes v in the generic representation.
+func (fw *FileWriter) WriteGeneric(v interface{}) error {
+	n := len(fw.enc.buf)
+	if err := fw.enc.WriteGeneric(fw.schema, v); err != nil {
+		fw.enc.buf = fw.enc.buf[:n]
+		return err
+	}
+	return fw.appended()
+}
+
+// WriteBlock writes count values already encoded in data as a block.
+func (fw *FileWriter) WriteBlock(count int, data []byte) error {
+	if err := fw.Flush(); err != nil {
+		return err
+	}
+	return fw.writeBlock(count, data)
+}
+
+func (fw *FileWriter) appended() error {
+	fw.count++
+	if len(fw.enc.buf) >= blockSize {
+		return fw.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered values as a block.
+func (fw *FileWriter) Flush() error {
+	if err := fw.writeHeader(); err != nil {
+		return err
+	}
+	if fw.count == 0 {
+		return nil
+	}
+	err := fw.writeBlock(fw.count, fw.enc.buf)
+	fw.count = 0
+	fw.enc.buf = fw.enc.buf[:0]
+	return err
+}
+
+// Close flushes the FileWriter. It does not close the underlying writer.
+func (fw *FileWriter) Close() error {
+	return fw.Flush()
+}
+
+func (fw *FileWriter) writeHeader() error {
+	if fw.wroteHeader {
+		return nil
+	}
+	fw.wroteHeader = true
+	fw.meta["avro.schema"] = []byte(fw.schema.String())
+	fw.meta["avro.codec"] = []byte(fw.codec)
+
+	b := []byte(containerMagic)
+	b = AppendLong(b, int64(len(fw.meta)))
+	for _, k := range sortedMetaKeys(fw.meta) {
+		b = AppendString(b, k)
+		b = AppendBytes(b, fw.meta[k])
+	}
+	b = AppendLong(b, 0)
+	b = append(b, fw.sync[:]...)
+	_, err := fw.w.Write(b)
+	return err
+}
+
+func (fw *FileWriter) writeBlock(count int, data []byte) error {
+	if err := fw.writeHeader(); err != nil {
+		return err
+	}
+	if fw.codec == CodecDeflate {
+		var buf bytes.Buffer
+		zw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	b := AppendLong(nil, int64(count))
+	b = AppendLong(b, int64(len(data)))
+	if _, err := fw.w.Write(b); err != nil {
+		return err
+	}
+	if _, err := fw.w.Write(data); err != nil {
+		return err
+	}
+	_, err := fw.w.Write(fw.sync[:])
+	return err
+}
+
+// FileReader reads an object container file.
+type FileReader struct {
+	d      *Decoder
+	schema *Schema
+	codec  string
+	meta   map[string][]byte
+	sync   [syncSize]byte
+
+	block *Decoder // values of the current block
+	count int      // values left in the current block
+
+	maxBlockSize int
+}
+
+// NewFileReader reads the header of the container file in r.
+func NewFileReader(r io.Reader) (*FileReader, error) {
+	fr := &FileReader{
+		d:            NewDecoder(r),
+		meta:         make(map[string][]byte),
+		block:        NewBytesDecoder(nil),
+		maxBlockSize: DefaultMaxBlockSize,
+	}
+	magic := make([]byte, len(containerMagic))
+	if err := fr.d.ReadFixed(magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != containerMagic {
+		return nil, fmt.Errorf("not a container file")
+	}
+	n, err := fr.d.ReadMapStart()
+	for ; n > 0 && err == nil; n, err = fr.d.ReadMapNext() {
+		for i := 0; i < n; i++ {
+			k, err := fr.d.ReadString()
+			if err != nil {
+				return nil, err
+			}
+			v, err := fr.d.ReadBytes()
+			if err != nil {
+				return nil, err
+			}
+			fr.meta[k] = v
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := fr.d.ReadFixed(fr.sync[:]); err != nil {
+		return nil, err
+	}
+
+	fr.schema, err = ParseSchema(fr.meta["avro.schema"])
+	if err != nil {
+		return nil, fmt.Errorf("file schema:%s", err)
+	}
+	fr.codec = string(fr.meta["avro.codec"])
+	if fr.codec == "" {
+		fr.codec = CodecNull
+	}
+	if fr.codec != CodecNull && fr.codec != CodecDeflate {
+		return nil, fmt.Errorf("not supported codec:%s", fr.codec)
+	}
+	return fr, nil
+}
+
+// Schema returns the schema of the file.
+func (fr *FileReader) Schema() *Schema {
+	return fr.schema
+}
+
+// Codec returns the codec of the blocks of the file.
+func (fr *FileReader) Codec() string {
+	return fr.codec
+}
+
+// Meta returns the metadata of the header.
+func (fr *FileReader) Meta() map[string][]byte {
+	return fr.meta
+}
+
+// SetMaxBlockSize sets the size of the largest block fr reads once
+// decompressed, DefaultMaxBlockSize by default. It bounds the memory a
+// small compressed block may take.
+func (fr *FileReader) SetMaxBlockSize(n int) {
+	fr.maxBlockSize = n
+}
+
+// Decode reads the next value into x. It returns io.EOF after the last
+// value.
+func (fr *FileReader) Decode(x interface{}) error {
+	if err := fr.next(); err != nil {
+		return err
+	}
+	fr.count--
+	return fr.block.Decode(x)
+}
+
+// ReadGeneric reads the next value in the generic representation. It
+// returns io.EOF after the last value.
+func (fr *FileReader) ReadGeneric() (interface{}, error) {
+	if err := fr.next(); err != nil {
+		return nil, err
+	}
+	fr.count--
+	return fr.block.ReadGeneric(fr.schema)
+}
+
+func (fr *FileReader) next() error {
+	for fr.count == 0 {
+		count, data, err := fr.ReadBlock()
+		if err != nil {
+			return err
+		}
+		fr.block.ResetBytes(data)
+		fr.count = count
+	}
+	return nil
+}
+
+// ReadBlock reads the next block, skipping the values left in the
+// current one, and returns its number of values and their decompressed
+// encoding. It returns io.EOF after the last block.
+func (fr *FileReader) ReadBlock() (int, []byte, error) {
+	fr.count = 0
+	count, err := fr.d.ReadLong()
+	if err != nil {
+		return 0, nil, err
+	}
+	if count < 0 {
+		return 0, nil, fmt.Errorf("negative block count:%d", count)
+	}
+	data, err := fr.d.ReadBytes()
+	if err != nil {
+		return 0, nil, err
+	}
+	var sync [syncSize]byte
+	if err := fr.d.ReadFixed(sync[:]); err != nil {
+		return 0, nil, err
+	}
+	if sync != fr.sync {
+		return 0, nil, fmt.Errorf("invalid sync marker")
+	}
+	if fr.codec == CodecDeflate {
+		r := flate.NewReader(bytes.NewReader(data))
+		data, err = io.ReadAll(io.LimitReader(r, int64(fr.maxBlockSize)+1))
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if len(data) > fr.maxBlockSize {
+		return 0, nil, fmt.Errorf("block larger than %d bytes", fr.maxBlockSize)
+	}
+	return int(count), data, nil
+}
+
+func sortedMetaKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,131 @@
+package avro
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestContainerFile(t *testing.T) {
+	for _, codec := range []string{CodecNull, CodecDeflate} {
+		var buf bytes.Buffer
+		fw, err := NewFileWriter(&buf, nodeSchema, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.SetMeta("user", []byte("meta"))
+		const n = 10000
+		for i := 0; i < n; i++ {
+			if err := fw.WriteGeneric(nodeValue); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		fr, err := NewFileReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fr.Codec() != codec || string(fr.Meta()["user"]) != "meta" {
+			t.Error(fr.Codec(), fr.Meta())
+		}
+		if fr.Schema().String() != nodeSchema.String() {
+			t.Error(fr.Schema())
+		}
+		var count int
+		for {
+			v, err := fr.ReadGeneric()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(v, nodeValue) {
+				t.Fatal(v)
+			}
+			count++
+		}
+		if count != n {
+			t.Errorf("%s: read %d values, expect %d", codec, count, n)
+		}
+	}
+}
+
+func TestContainerFileDecode(t *testing.T) {
+	s, err := SchemaOf(flat{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	fw, err := NewFileWriter(&buf, s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := []flat{{Int: 1, Bytes: []byte{}}, {Int: 2, String: "b", Bytes: []byte{1}}}
+	for _, x := range in {
+		if err := fw.Encode(x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw.Close()
+
+	fr, err := NewFileReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []flat
+	for {
+		var x flat
+		if err := fr.Decode(&x); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, x)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Error(out)
+	}
+
+	if _, err := NewFileWriter(&buf, s, "snappy"); err == nil {
+		t.Error("snappy is not supported")
+	}
+	if _, err := NewFileReader(bytes.NewReader([]byte("Obj\x02"))); err == nil {
+		t.Error("bad magic")
+	}
+}
+
+func TestContainerMaxBlockSize(t *testing.T) {
+	var buf bytes.Buffer
+	fw, err := NewFileWriter(&buf, MustParseSchema(`"bytes"`), CodecDeflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a block of a megabyte of zeros, which deflates to a few kilobytes
+	if err := fw.WriteGeneric(make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fr, err := NewFileReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := fr.ReadGeneric(); err != nil || len(v.([]byte)) != 1<<20 {
+		t.Fatal(err)
+	}
+	fr, err = NewFileReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.SetMaxBlockSize(1 << 16)
+	if _, err := fr.ReadGeneric(); err == nil {
+		t.Error("block over the limit")
+	}
+}
@@ -1,84 +1,384 @@
 package avro
 
 import (
-        "avro/zigzag"
-        "bufio"
-        "bytes"
-        "encoding/binary"
-        "fmt"
-        "io"
-        "reflect"
+	"avro/zigzag"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"unsafe"
 )
 
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		return NewBytesDecoder(nil)
+	},
+}
+
 func Unmarshal(b []byte, x interface{}) error {
-        buf := bytes.NewBuffer(b)
-        dec := NewDecoder(buf)
-        return dec.Decode(x)
+	dec := decoderPool.Get().(*Decoder)
+	dec.ResetBytes(b)
+	err := dec.Decode(x)
+	dec.ResetBytes(nil)
+	decoderPool.Put(dec)
+	return err
 }
 
+// A Decoder reads avro binary encoded values from an input stream or
+// directly from a byte slice. A Decoder can be reused with Reset or
+// ResetBytes, which keeps its buffers, e.g. in a sync.Pool.
 type Decoder struct {
-        r *bufio.Reader
+	r         byteReader
+	buf       *bufio.Reader // buffers readers which are not byteReaders
+	b         []byte        // unread input of a byte slice decoder
+	fromBytes bool
+	zeroCopy  bool
+	scratch   [8]byte
+	read      int64 // bytes read
+	maxItems  int64
+	empty     int64 // array and map items read without data
 }
 
+// DefaultMaxItems is the number of array and map items a Decoder reads
+// without data until it is reset.
+const DefaultMaxItems = 1 << 16
+
+// byteReader is a reader the Decoder reads from without buffering.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// NewDecoder returns a Decoder reading from r. r is buffered unless it is
+// an io.ByteReader, such as a *bufio.Reader or a *bytes.Reader, which is
+// read directly.
 func NewDecoder(r io.Reader) *Decoder {
-        return &Decoder{
-                bufio.NewReader(r),
-        }
+	d := new(Decoder)
+	d.Reset(r)
+	return d
+}
+
+// NewBytesDecoder returns a Decoder reading from b without buffering.
+func NewBytesDecoder(b []byte) *Decoder {
+	return &Decoder{
+		b:         b,
+		fromBytes: true,
+	}
+}
+
+// Reset discards any buffered data and makes d read from r.
+func (d *Decoder) Reset(r io.Reader) {
+	if br, ok := r.(byteReader); ok {
+		d.r = br
+	} else {
+		if d.buf == nil {
+			d.buf = bufio.NewReader(r)
+		} else {
+			d.buf.Reset(r)
+		}
+		d.r = d.buf
+	}
+	d.b = nil
+	d.fromBytes = false
+	d.empty = 0
+}
+
+// ResetBytes makes d read from b.
+func (d *Decoder) ResetBytes(b []byte) {
+	d.b = b
+	d.fromBytes = true
+	d.empty = 0
+}
+
+// SetZeroCopy makes a byte slice decoder return []byte and string values
+// aliasing its input instead of copies. The input must not be modified
+// while the decoded values are in use. It has no effect when reading
+// from a stream.
+func (d *Decoder) SetZeroCopy(on bool) {
+	d.zeroCopy = on
+}
+
+// SetMaxItems sets the number of array and map items reading no data,
+// such as nulls, d reads in all before failing, until it is reset,
+// DefaultMaxItems by default. It bounds the work of decoding block counts
+// of items read from no input, nested or not.
+func (d *Decoder) SetMaxItems(n int) {
+	d.maxItems = int64(n)
+}
+
+// itemCount counts the items of an array or map read by d without data.
+type itemCount struct {
+	d    *Decoder
+	last int64 // bytes read before the previous item, -1 before the first
+}
+
+func (d *Decoder) countItems() itemCount {
+	return itemCount{d: d, last: -1}
+}
+
+// next counts the previous item if it read no data, before reading
+// another.
+func (c *itemCount) next() error {
+	d := c.d
+	if c.last == d.read {
+		d.empty++
+		max := d.maxItems
+		if max <= 0 {
+			max = DefaultMaxItems
+		}
+		if d.empty > max {
+			return fmt.Errorf("too many items without data:%d", d.empty)
+		}
+	}
+	c.last = d.read
+	return nil
 }
 
 func (d *Decoder) Decode(x interface{}) error {
-        if x == nil {
-                return nil
-        }
-        var err error
-        switch v := x.(type) {
-        case *Null:
-                return nil
-        case *bool:
-                var n byte
-                err = binary.Read(d.r, binary.BigEndian, &n)
-                if err != nil {
-                        break
-                }
-                if n == 0 {
-                        *v = false
-                } else {
-                        *v = true
-                }
-        case *int, *int8, *int32, *int64:
-                u, err := binary.ReadUvarint(d.r)
-                if err != nil {
-                        break
-                }
-                reflect.ValueOf(v).Elem().SetInt(zigzag.Decode(int64(u)))
-        case *uint, *uint8, *uint32, *uint64:
-                u, err := binary.ReadUvarint(d.r)
-                if err != nil {
-                        break
-                }
-                reflect.ValueOf(v).Elem().SetUint(uint64(zigzag.Decode(int64(u))))
-        case *float32, *float64:
-                err = binary.Read(d.r, binary.BigEndian, v)
-        case *[]byte:
-                var n int32
-                err = d.Decode(&n)
-                if err != nil {
-                        break
-                }
-                *v = make([]byte, n)
-                _, err = io.ReadFull(d.r, *v)
-        case *string:
-                var b []byte
-                err = d.Decode(&b)
-                if err != nil {
-                        break
-                }
-                *v = string(b)
-        default:
-                err = d.decodeComplex(x)
-        }
-
-        return err
+	if x == nil {
+		return nil
+	}
+	var err error
+	switch v := x.(type) {
+	case *Null, **Null:
+		return nil
+	case *bool:
+		var n byte
+		n, err = d.readByte()
+		if err != nil {
+			break
+		}
+		if n == 0 {
+			*v = false
+		} else {
+			*v = true
+		}
+	case *int8, *int16, *int32:
+		var n int64
+		n, err = d.readInt()
+		if err != nil {
+			break
+		}
+		err = setInt(reflect.ValueOf(v).Elem(), n)
+	case *int, *int64:
+		var n int64
+		n, err = d.readLong()
+		if err != nil {
+			break
+		}
+		err = setInt(reflect.ValueOf(v).Elem(), n)
+	case *uint8, *uint16:
+		var n int64
+		n, err = d.readInt()
+		if err != nil {
+			break
+		}
+		err = setUint(reflect.ValueOf(v).Elem(), n)
+	case *uint, *uint32, *uint64:
+		var n int64
+		n, err = d.readLong()
+		if err != nil {
+			break
+		}
+		err = setUint(reflect.ValueOf(v).Elem(), n)
+	case *float32:
+		var b []byte
+		b, err = d.next(4)
+		if err != nil {
+			break
+		}
+		*v = math.Float32frombits(binary.LittleEndian.Uint32(b))
+	case *float64:
+		var b []byte
+		b, err = d.next(8)
+		if err != nil {
+			break
+		}
+		*v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case *[]byte:
+		*v, err = d.readBytes()
+	case *string:
+		var b []byte
+		b, err = d.readBytes()
+		if err != nil {
+			break
+		}
+		if len(b) == 0 {
+			*v = ""
+		} else if d.fromBytes && !d.zeroCopy {
+			*v = string(b)
+		} else {
+			// b is either owned by us or aliasing is wanted
+			*v = unsafe.String(&b[0], len(b))
+		}
+	case Unmarshaler:
+		err = v.UnmarshalAvro(d)
+	case Enum:
+		err = d.decodeEnum(v)
+	default:
+		err = d.decodeComplex(x)
+	}
+
+	return err
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if !d.fromBytes {
+		c, err := d.r.ReadByte()
+		if err == nil {
+			d.read++
+		}
+		return c, err
+	}
+	if len(d.b) == 0 {
+		return 0, io.EOF
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	d.read++
+	return c, nil
+}
+
+// next returns the next n bytes, n <= len(d.scratch). The result is only
+// valid until the next read.
+func (d *Decoder) next(n int) ([]byte, error) {
+	if d.fromBytes {
+		if len(d.b) < n {
+			d.b = nil
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := d.b[:n]
+		d.b = d.b[n:]
+		d.read += int64(n)
+		return b, nil
+	}
+	b := d.scratch[:n]
+	m, err := io.ReadFull(d.r, b)
+	d.read += int64(m)
+	return b, err
+}
+
+func (d *Decoder) readFull(b []byte) error {
+	if !d.fromBytes {
+		n, err := io.ReadFull(d.r, b)
+		d.read += int64(n)
+		return err
+	}
+	if len(d.b) < len(b) {
+		d.b = nil
+		return io.ErrUnexpectedEOF
+	}
+	copy(b, d.b)
+	d.b = d.b[len(b):]
+	d.read += int64(len(b))
+	return nil
+}
+
+// maxPrealloc is the largest length of bytes read from a stream which is
+// allocated before reading them.
+const maxPrealloc = 1 << 16
+
+// readBytes reads avro bytes. When reading a byte slice the result aliases
+// the input, and is copied unless zero copy is enabled. Otherwise the
+// result is newly allocated.
+func (d *Decoder) readBytes() ([]byte, error) {
+	n, err := d.readLong()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("negative bytes length:%d", n)
+	}
+	if !d.fromBytes {
+		if n <= maxPrealloc {
+			b := make([]byte, n)
+			m, err := io.ReadFull(d.r, b)
+			d.read += int64(m)
+			return b, err
+		}
+		// the length is untrusted, so grow with the data read
+		var buf bytes.Buffer
+		m, err := io.CopyN(&buf, d.r, n)
+		d.read += m
+		if m < n && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf.Bytes(), err
+	}
+	if int64(len(d.b)) < n {
+		d.b = nil
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.b[:n:n]
+	d.b = d.b[n:]
+	d.read += n
+	if d.zeroCopy {
+		return b, nil
+	}
+	return append([]byte{}, b...), nil
+}
+
+// readInt reads an avro int, a zigzag varint of at most 5 bytes.
+func (d *Decoder) readInt() (int64, error) {
+	u, err := d.readVarint(32)
+	if err != nil {
+		return 0, err
+	}
+	return zigzag.Decode(int64(u)), nil
+}
+
+// readLong reads an avro long, a zigzag varint of at most 10 bytes.
+func (d *Decoder) readLong() (int64, error) {
+	u, err := d.readVarint(64)
+	if err != nil {
+		return 0, err
+	}
+	return zigzag.Decode(int64(u)), nil
+}
+
+// readVarint reads a varint holding at most bits bits, rejecting
+// encodings that are longer than needed for that size.
+func (d *Decoder) readVarint(bits uint) (uint64, error) {
+	var x uint64
+	var s uint
+	for s < bits {
+		b, err := d.readByte()
+		if err != nil {
+			if s > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if b < 0x80 {
+			if bits-s < 7 && b >= 1<<(bits-s) {
+				break
+			}
+			return x | uint64(b)<<s, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+	return 0, fmt.Errorf("varint overflows a %d-bit integer", bits)
+}
+
+func setInt(v reflect.Value, n int64) error {
+	if v.OverflowInt(n) {
+		return fmt.Errorf("value %d overflows %s", n, v.Type())
+	}
+	v.SetInt(n)
+	return nil
+}
+
+func setUint(v reflect.Value, n int64) error {
+	if n < 0 || v.OverflowUint(uint64(n)) {
+		return fmt.Errorf("value %d overflows %s", n, v.Type())
+	}
+	v.SetUint(uint64(n))
+	return nil
 }
 
 // for map and union
@@ -87,142 +387,179 @@ func (d *Decoder) Decode(x interface{}) error {
 // for fixed use array
 // for array use slice
 func (d *Decoder) decodeComplex(x interface{}) error {
-        if _, ok := x.(*Union); ok {
-                return d.decodeUnion(x)
-        }
-
-        p := reflect.ValueOf(x)
-        if p.Kind() != reflect.Ptr {
-                return fmt.Errorf("decodeComplex need ptr:%s", p.Type())
-        }
-
-        switch p.Elem().Kind() {
-        case reflect.Array:
-                return d.decodeArray(x)
-        case reflect.Slice:
-                return d.decodeSlice(x)
-        case reflect.Map:
-                return d.decodeMap(x)
-        case reflect.Struct:
-                return d.decodeStruct(x)
-        default:
-                panic(fmt.Errorf("not supported:%s", p.Type()))
-        }
-        return nil
+	if _, ok := x.(*Union); ok {
+		return d.decodeUnion(x)
+	}
+
+	p := reflect.ValueOf(x)
+	if p.Kind() != reflect.Ptr {
+		return fmt.Errorf("decodeComplex need ptr:%s", p.Type())
+	}
+
+	switch p.Elem().Kind() {
+	case reflect.Ptr:
+		return d.decodeNullable(p.Elem(), false)
+	case reflect.Interface:
+		u := lookupUnion(p.Elem().Type())
+		if u == nil {
+			return fmt.Errorf("not registered union:%s", p.Elem().Type())
+		}
+		return d.decodeTypedUnion(u, p.Elem())
+	case reflect.Array:
+		return d.decodeArray(x)
+	case reflect.Slice:
+		return d.decodeSlice(x)
+	case reflect.Map:
+		return d.decodeMap(x)
+	case reflect.Struct:
+		return d.decodeStruct(x)
+	default:
+		panic(fmt.Errorf("not supported:%s", p.Type()))
+	}
 }
 
 func (d *Decoder) decodeUnion(x interface{}) error {
-        v := x.(*Union)
-        err := d.Decode(&v.Idx)
-        if err != nil {
-                return err
-        }
-        if v.Idx >= len(v.Elem) || v.Idx < 0 {
-                return fmt.Errorf("union index error:%d", v.Idx)
-        }
-        return d.Decode(v.Elem[v.Idx])
+	v := x.(*Union)
+	err := d.Decode(&v.Idx)
+	if err != nil {
+		return err
+	}
+	if v.Idx >= len(v.Elem) || v.Idx < 0 {
+		return fmt.Errorf("union index error:%d", v.Idx)
+	}
+	return d.Decode(v.Elem[v.Idx])
+}
+
+// decodeNullable reads a ["null", T] union, or a [T, "null"] union if
+// nullSecond is set, into the pointer v. v is allocated if needed and
+// set to nil for the null branch.
+func (d *Decoder) decodeNullable(v reflect.Value, nullSecond bool) error {
+	null, elem := int64(0), int64(1)
+	if nullSecond {
+		null, elem = 1, 0
+	}
+	idx, err := d.readInt()
+	if err != nil {
+		return err
+	}
+	switch idx {
+	case null:
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	case elem:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.Decode(v.Interface())
+	}
+	return fmt.Errorf("union index error:%d", idx)
 }
 
 func (d *Decoder) decodeArray(x interface{}) error {
-        t := reflect.TypeOf(x).Elem()
-        if t.Elem().Kind() != reflect.Uint8 {
-                return fmt.Errorf("element of fixed must be byte:%s", t)
-        }
-        return binary.Read(d.r, binary.BigEndian, x)
+	v := reflect.ValueOf(x).Elem()
+	t := v.Type()
+	if t.Elem().Kind() != reflect.Uint8 {
+		return fmt.Errorf("element of fixed must be byte:%s", t)
+	}
+	return d.readFull(v.Bytes())
 }
 
 func (d *Decoder) decodeMap(x interface{}) error {
-        v := reflect.ValueOf(x).Elem()
-        t := v.Type()
-        if t.Key().Kind() != reflect.String {
-                return fmt.Errorf("key of map must be string:%s", t.Key())
-        }
-
-        if v.IsNil() {
-                v.Set(reflect.MakeMap(t))
-        }
-
-        var blkcnt int
-        err := d.Decode(&blkcnt)
-        if err != nil {
-                return err
-        }
-        for blkcnt != 0 {
-                if blkcnt < 0 {
-                        blkcnt = -blkcnt
-                        var n int
-                        err = d.Decode(&n)
-                        if err != nil {
-                                return err
-                        }
-                }
-                for i := 0; i < blkcnt; i++ {
-                        key := reflect.New(t.Key())
-                        err := d.Decode(key.Interface())
-                        if err != nil {
-                                return err
-                        }
-                        value := reflect.New(t.Elem())
-                        err = d.Decode(value.Interface())
-                        if err != nil {
-                                return err
-                        }
-                        v.SetMapIndex(key.Elem(), value.Elem())
-                }
-                err = d.Decode(&blkcnt)
-                if err != nil {
-                        return err
-                }
-
-        }
-        return nil
+	v := reflect.ValueOf(x).Elem()
+	t := v.Type()
+	if t.Key().Kind() != reflect.String {
+		return fmt.Errorf("key of map must be string:%s", t.Key())
+	}
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+
+	items := d.countItems()
+	blkcnt, err := d.ReadMapStart()
+	if err != nil {
+		return err
+	}
+	for blkcnt != 0 {
+		for i := 0; i < blkcnt; i++ {
+			if err := items.next(); err != nil {
+				return err
+			}
+			key := reflect.New(t.Key())
+			err := d.Decode(key.Interface())
+			if err != nil {
+				return err
+			}
+			value := reflect.New(t.Elem())
+			err = d.Decode(value.Interface())
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(key.Elem(), value.Elem())
+		}
+		blkcnt, err = d.ReadMapNext()
+		if err != nil {
+			return err
+		}
+
+	}
+	return nil
 }
 
 func (d *Decoder) decodeStruct(x interface{}) error {
-        v := reflect.ValueOf(x).Elem()
-        t := v.Type()
-        n := t.NumField()
-        for i := 0; i < n; i++ {
-                f := v.Field(i)
-                if f.CanSet() {
-                        err := d.Decode(f.Addr().Interface())
-                        if err != nil {
-                                return fmt.Errorf("decode %s:%s", t.Field(i).Name, err)
-                        }
-                }
-        }
-        return nil
+	v := reflect.ValueOf(x).Elem()
+	t := v.Type()
+	n := t.NumField()
+	for i := 0; i < n; i++ {
+		f := v.Field(i)
+		if f.CanSet() {
+			var err error
+			_, opts := parseTag(t.Field(i).Tag.Get("avro"))
+			if f.Kind() == reflect.Ptr && opts.Contains("nullsecond") {
+				err = d.decodeNullable(f, true)
+			} else {
+				err = d.Decode(f.Addr().Interface())
+			}
+			if err != nil {
+				return fmt.Errorf("decode %s:%s", t.Field(i).Name, err)
+			}
+		}
+	}
+	return nil
 }
 
 func (d *Decoder) decodeSlice(x interface{}) error {
-        v := reflect.ValueOf(x).Elem()
-        t := v.Type()
-        if t.Elem().Kind() == reflect.Interface {
-                return fmt.Errorf("element of slice must be concrete type, not interface")
-        }
-        if v.IsNil() {
-                v.Set(reflect.MakeSlice(t, 0, 4))
-        }
-
-        var n int
-        err := d.Decode(&n)
-        if err != nil {
-                return err
-        }
-
-        for n != 0 {
-                for i := 0; i < n; i++ {
-                        elem := reflect.New(t.Elem())
-                        err := d.Decode(elem.Interface())
-                        if err != nil {
-                                return err
-                        }
-                        v.Set(reflect.Append(v, elem.Elem()))
-                }
-                err := d.Decode(&n)
-                if err != nil {
-                        return err
-                }
-        }
-        return nil
+	v := reflect.ValueOf(x).Elem()
+	t := v.Type()
+	if t.Elem().Kind() == reflect.Interface && lookupUnion(t.Elem()) == nil {
+		return fmt.Errorf("element of slice must be concrete type, not interface")
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeSlice(t, 0, 4))
+	}
+
+	items := d.countItems()
+	n, err := d.ReadArrayStart()
+	if err != nil {
+		return err
+	}
+
+	for n != 0 {
+		for i := 0; i < n; i++ {
+			if err := items.next(); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem())
+			err := d.Decode(elem.Interface())
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, elem.Elem()))
+		}
+		n, err = d.ReadArrayNext()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
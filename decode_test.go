@@ -1,132 +1,339 @@
 package avro
 
 import (
-        "bytes"
-        "reflect"
-        "testing"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
 )
 
 func RunCase(t *testing.T, c interface{}) {
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-        dec := NewDecoder(buf)
-        v := reflect.ValueOf(c)
-        for i := 0; i < v.Len(); i++ {
-                item := v.Index(i)
-                if err := enc.Encode(item.Interface()); err != nil {
-                        t.Error(err)
-                }
-                d := reflect.New(item.Type())
-                tp := d.Elem().Type()
-                switch tp.Kind() {
-                case reflect.Map:
-                        d.Elem().Set(reflect.MakeMap(tp))
-                case reflect.Slice:
-                        d.Elem().Set(reflect.MakeSlice(tp, 0, 0))
-                }
-                if err := dec.Decode(d.Interface()); err != nil {
-                        t.Error(err)
-                }
-                if reflect.DeepEqual(reflect.Indirect(d), v) {
-                        t.Error(d)
-                }
-        }
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	dec := NewDecoder(buf)
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+		if err := enc.Encode(item.Interface()); err != nil {
+			t.Error(err)
+		}
+		d := reflect.New(item.Type())
+		tp := d.Elem().Type()
+		switch tp.Kind() {
+		case reflect.Map:
+			d.Elem().Set(reflect.MakeMap(tp))
+		case reflect.Slice:
+			d.Elem().Set(reflect.MakeSlice(tp, 0, 0))
+		}
+		if err := dec.Decode(d.Interface()); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(d.Elem().Interface(), item.Interface()) {
+			t.Errorf("%#v != %#v", d.Elem().Interface(), item.Interface())
+		}
+	}
 }
 
 func TestDecodeNil(t *testing.T) {
-        RunCase(t, []Null{Null(0)})
+	RunCase(t, []Null{Null(0)})
 }
 
 func TestDecodeInt(t *testing.T) {
-        RunCase(t, []int{0, 1, 1, 2, 3, 5})
-        RunCase(t, []int32{0, 1, 1, 2, 3, 5})
-        RunCase(t, []int64{0, 1, 1, 2, 3, 5})
-        RunCase(t, []uint32{0, 1, 1, 2, 3, 5})
-        RunCase(t, []uint64{0, 1, 1, 2, 3, 5})
+	RunCase(t, []int{0, 1, 1, 2, 3, 5})
+	RunCase(t, []int32{0, 1, 1, 2, 3, 5})
+	RunCase(t, []int64{0, 1, 1, 2, 3, 5})
+	RunCase(t, []uint32{0, 1, 1, 2, 3, 5})
+	RunCase(t, []uint64{0, 1, 1, 2, 3, 5})
 }
 
 func TestDecodeFloat(t *testing.T) {
-        RunCase(t, []float32{0, 1, 1, 2, 3, 5})
-        RunCase(t, []float64{0, 1, 1, 2, 3, 5})
+	RunCase(t, []float32{0, 1, 1, 2, 3, 5})
+	RunCase(t, []float64{0, 1, 1, 2, 3, 5})
 }
 
 func TestDecodeBool(t *testing.T) {
-        RunCase(t, []bool{true, false})
+	RunCase(t, []bool{true, false})
 }
 
 func TestDecodeString(t *testing.T) {
-        RunCase(t, []string{"", "a", "abc"})
+	RunCase(t, []string{"", "a", "abc"})
 }
 
 func TestDecodeFixed(t *testing.T) {
-        RunCase(t, [][3]byte{[3]byte{1, 2, 3}})
+	RunCase(t, [][3]byte{[3]byte{1, 2, 3}})
 }
 
 func TestDecodeArray(t *testing.T) {
-        RunCase(t, [][]string{
-                []string{"", "a", "ab"},
-                []string{"1234", "a", "ab"},
-        })
+	RunCase(t, [][]string{
+		[]string{"", "a", "ab"},
+		[]string{"1234", "a", "ab"},
+	})
 }
 
 func TestDecodeMap(t *testing.T) {
-        RunCase(t, []map[string]string{
-                map[string]string{},
-                map[string]string{"key": "value"},
-        })
+	RunCase(t, []map[string]string{
+		map[string]string{},
+		map[string]string{"key": "value"},
+	})
 }
 
 type record struct {
-        Int    int
-        Nil    Null
-        skip   int
-        Fixed  [3]byte
-        String string
+	Int    int
+	Nil    Null
+	skip   int
+	Fixed  [3]byte
+	String string
 }
 
 func TestDecodeStruct(t *testing.T) {
-        RunCase(t, []record{
-                record{
-                        1,
-                        Null(0),
-                        2,
-                        [3]byte{1, 2, 3},
-                        "abc",
-                },
-        })
+	RunCase(t, []record{
+		record{
+			1,
+			Null(0),
+			0, // unexported, not encoded
+			[3]byte{1, 2, 3},
+			"abc",
+		},
+	})
 }
 
 func TestDecodeUnion(t *testing.T) {
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-        dec := NewDecoder(buf)
-        uni := Union{
-                Idx: 1,
-                Elem: []interface{}{
-                        Null(0),
-                        "abc",
-                },
-        }
-        if err := enc.Encode(uni); err != nil {
-                t.Fatal(err)
-        }
-
-        uni1 := Union{
-                Elem: []interface{}{
-                        Null(0),
-                        new(string),
-                },
-        }
-
-        if err := dec.Decode(&uni1); err != nil {
-                t.Fatal(err)
-        }
-
-        if uni1.Idx != 1 {
-                t.Fatal(uni1.Idx)
-        }
-        s := uni1.Elem[1].(*string)
-        if *s != "abc" {
-                t.Fatal(*s)
-        }
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	dec := NewDecoder(buf)
+	uni := Union{
+		Idx: 1,
+		Elem: []interface{}{
+			Null(0),
+			"abc",
+		},
+	}
+	if err := enc.Encode(uni); err != nil {
+		t.Fatal(err)
+	}
+
+	uni1 := Union{
+		Elem: []interface{}{
+			Null(0),
+			new(string),
+		},
+	}
+
+	if err := dec.Decode(&uni1); err != nil {
+		t.Fatal(err)
+	}
+
+	if uni1.Idx != 1 {
+		t.Fatal(uni1.Idx)
+	}
+	s := uni1.Elem[1].(*string)
+	if *s != "abc" {
+		t.Fatal(*s)
+	}
+}
+
+func TestDecodeIntRange(t *testing.T) {
+	RunCase(t, []int16{0, -1, 1 << 14, -1 << 15})
+	RunCase(t, []uint16{0, 1, 1 << 15})
+
+	b, _ := Marshal(int64(1 << 40))
+	var i32 int32
+	if err := Unmarshal(b, &i32); err == nil {
+		t.Error("long should not fit in int32")
+	}
+	var i64 int64
+	if err := Unmarshal(b, &i64); err != nil || i64 != 1<<40 {
+		t.Error(i64, err)
+	}
+
+	b, _ = Marshal(300)
+	var i8 int8
+	if err := Unmarshal(b, &i8); err == nil {
+		t.Error("300 should not fit in int8")
+	}
+
+	b, _ = Marshal(-1)
+	var u uint64
+	if err := Unmarshal(b, &u); err == nil {
+		t.Error("-1 should not fit in uint64")
+	}
+}
+
+func TestDecodeVarintOverflow(t *testing.T) {
+	var i32 int32
+	// 6 bytes is too long for an int
+	if err := Unmarshal([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, &i32); err == nil {
+		t.Error("should overflow int")
+	}
+	// the 5th byte of an int only holds 4 bits
+	if err := Unmarshal([]byte{0xff, 0xff, 0xff, 0xff, 0x1f}, &i32); err == nil {
+		t.Error("should overflow int")
+	}
+	if err := Unmarshal([]byte{0xfe, 0xff, 0xff, 0xff, 0x0f}, &i32); err != nil || i32 != 2147483647 {
+		t.Error(i32, err)
+	}
+
+	var i64 int64
+	b := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}
+	if err := Unmarshal(b, &i64); err == nil {
+		t.Error("should overflow long")
+	}
+	b[9] = 0x01
+	if err := Unmarshal(b, &i64); err != nil || i64 != -9223372036854775808 {
+		t.Error(i64, err)
+	}
+	if err := Unmarshal([]byte{0x80}, &i64); err == nil {
+		t.Error("should be unexpected EOF")
+	}
+}
+
+type nullable struct {
+	Int    *int
+	String *string `avro:",nullsecond"`
+	Rec    *record
+	Ints   []*int
+}
+
+func TestDecodeNullable(t *testing.T) {
+	i, s := 3, "abc"
+	in := nullable{
+		Int:    &i,
+		String: &s,
+		Rec:    &record{Int: 1, String: "x"},
+		Ints:   []*int{nil, &i},
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []byte{
+		2, 6, // Int
+		0, 6, 0x61, 0x62, 0x63, // String
+		2, 2, 0, 0, 0, 2, 0x78, // Rec
+		4, 0, 2, 6, 0, // Ints
+	}
+	if !bytes.Equal(b, expect) {
+		t.Fatal(b)
+	}
+	var out nullable
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Error(out)
+	}
+
+	b, err = Marshal(nullable{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{0, 2, 0, 0}) {
+		t.Fatal(b)
+	}
+	var empty nullable
+	if err := Unmarshal(b, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(empty, nullable{Ints: []*int{}}) {
+		t.Error(empty)
+	}
+}
+
+type flat struct {
+	Int    int
+	Bool   bool
+	Double float64
+	Fixed  [3]byte
+	Bytes  []byte
+	String string
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	in := flat{1, true, 2.5, [3]byte{1, 2, 3}, []byte("bytes"), "string"}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out flat
+	dec := NewBytesDecoder(b)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatal(out)
+	}
+	if &out.Bytes[0] == &b[len(b)-len("bytes")-len("string")-1] {
+		t.Error("bytes should be copied")
+	}
+
+	dec.ResetBytes(b)
+	dec.SetZeroCopy(true)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatal(out)
+	}
+	if &out.Bytes[0] != &b[len(b)-len("bytes")-len("string")-1] {
+		t.Error("bytes should alias the input")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		dec.ResetBytes(b)
+		dec.Decode(&out)
+	})
+	if allocs > 0 {
+		t.Error("zero copy decoding allocates", allocs)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	b, _ := Marshal("abc")
+	dec := NewDecoder(bytes.NewReader(b))
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "abc" {
+		t.Fatal(s, err)
+	}
+	if err := dec.Decode(&s); err != io.EOF {
+		t.Fatal(err)
+	}
+	dec.Reset(bytes.NewReader(b))
+	if err := dec.Decode(&s); err != nil || s != "abc" {
+		t.Fatal(s, err)
+	}
+	dec.ResetBytes(b[:2])
+	if err := dec.Decode(&s); err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoderByteReader(t *testing.T) {
+	b, _ := Marshal("abc")
+	b = append(b, "rest"...)
+
+	// a byte reader is read directly, up to the end of the value
+	r := bytes.NewReader(b)
+	dec := NewDecoder(r)
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "abc" {
+		t.Fatal(s, err)
+	}
+	if r.Len() != len("rest") {
+		t.Error(r.Len())
+	}
+
+	// other readers are buffered, reusing the buffer on Reset
+	dec.Reset(io.MultiReader(bytes.NewReader(b)))
+	buf := dec.buf
+	if err := dec.Decode(&s); err != nil || s != "abc" {
+		t.Fatal(s, err)
+	}
+	dec.Reset(io.MultiReader(bytes.NewReader(b)))
+	if dec.buf != buf {
+		t.Error("buffer not reused")
+	}
+	if err := dec.Decode(&s); err != nil || s != "abc" {
+		t.Fatal(s, err)
+	}
 }
@@ -1,146 +1,248 @@
 package avro
 
 import (
-        "avro/zigzag"
-        "bytes"
-        "encoding/binary"
-        "errors"
-        "fmt"
-        "io"
-        "reflect"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
 )
 
 func Marshal(x interface{}) ([]byte, error) {
-        var buf bytes.Buffer
-        enc := NewEncoder(&buf)
-        err := enc.Encode(x)
-        if err != nil {
-                return nil, err
-        }
-        return buf.Bytes(), nil
+	return AppendMarshal(nil, x)
 }
 
+// AppendMarshal appends the encoding of x to dst and returns the extended
+// buffer. On error dst is returned unchanged.
+func AppendMarshal(dst []byte, x interface{}) ([]byte, error) {
+	e := encoderPool.Get().(*Encoder)
+	e.buf = dst
+	err := e.WriteValue(x)
+	b := e.buf
+	e.buf = nil
+	encoderPool.Put(e)
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
+}
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return new(Encoder)
+	},
+}
+
+// An Encoder writes avro binary encoded values to an output stream.
+// The encoding is deterministic, encoding equal values always produces
+// the same bytes. In particular map entries are written in key order.
 type Encoder struct {
-        w   io.Writer
-        buf *bytes.Buffer
-        b   [10]byte
+	w      io.Writer
+	buf    []byte
+	schema *Schema
 }
 
 func NewEncoder(w io.Writer) *Encoder {
-        return &Encoder{
-                w:   w,
-                buf: new(bytes.Buffer),
-        }
+	return &Encoder{
+		w: w,
+	}
+}
+
+// Reset discards any buffered data and makes e write to w.
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.buf = e.buf[:0]
+}
+
+// SetSchema makes Encode validate each value with ValidateDatum before
+// writing it, so values which do not fit s are rejected instead of
+// written as corrupt data. A nil schema turns validation off.
+func (e *Encoder) SetSchema(s *Schema) {
+	e.schema = s
 }
 
 func (e *Encoder) Encode(x interface{}) error {
-        err := e.marshal(x)
-        if err != nil {
-                e.buf.Reset()
-                return err
-        }
-        _, err = e.buf.WriteTo(e.w)
-        return err
+	if e.schema != nil {
+		if err := ValidateDatum(e.schema, x); err != nil {
+			return err
+		}
+	}
+	n := len(e.buf)
+	err := e.WriteValue(x)
+	if err != nil {
+		e.buf = e.buf[:n]
+		return err
+	}
+	return e.Flush()
 }
 
 func (e *Encoder) marshal(x interface{}) error {
-        if x == nil {
-                return nil
-        }
-        switch v := x.(type) {
-        case Null, *Null:
-                return nil
-        case bool:
-                if v {
-                        binary.Write(e.buf, binary.BigEndian, byte(1))
-                } else {
-                        binary.Write(e.buf, binary.BigEndian, byte(0))
-                }
-        case int, int8, int16, int32, int64:
-                n := binary.PutUvarint(e.b[:], zigzag.Encode(reflect.ValueOf(v).Int()))
-                e.buf.Write(e.b[:n])
-        case uint, uint8, uint16, uint32, uint64:
-                n := binary.PutUvarint(e.b[:], zigzag.Encode(int64(reflect.ValueOf(v).Uint())))
-                e.buf.Write(e.b[:n])
-
-        case float32, float64:
-                binary.Write(e.buf, binary.BigEndian, v)
-        case []byte:
-                e.marshal(len(v))
-                e.buf.Write(v)
-        case string:
-                e.marshal([]byte(v))
-        default:
-                return e.marshalComlpex(x)
-        }
-        return nil
+	if x == nil {
+		return nil
+	}
+	// a pointer is a union of null and the element, even to an Enum or a
+	// Marshaler, as the Decoder reads it
+	if p := reflect.ValueOf(x); p.Kind() == reflect.Ptr {
+		if _, ok := x.(*Null); ok {
+			return nil
+		}
+		return e.marshalNullable(p, false)
+	}
+	switch v := x.(type) {
+	case Null:
+		return nil
+	case bool:
+		e.buf = AppendBool(e.buf, v)
+	case int:
+		e.buf = AppendLong(e.buf, int64(v))
+	case int8, int16, int32, int64:
+		e.buf = AppendLong(e.buf, reflect.ValueOf(v).Int())
+	case uint, uint8, uint16, uint32, uint64:
+		u := reflect.ValueOf(v).Uint()
+		if u > math.MaxInt64 {
+			return fmt.Errorf("value %d overflows long", u)
+		}
+		e.buf = AppendLong(e.buf, int64(u))
+	case float32:
+		e.buf = AppendFloat(e.buf, v)
+	case float64:
+		e.buf = AppendDouble(e.buf, v)
+	case []byte:
+		e.buf = AppendBytes(e.buf, v)
+	case string:
+		e.buf = AppendString(e.buf, v)
+	case Marshaler:
+		return v.MarshalAvro(e)
+	case Enum:
+		return e.marshalEnum(v)
+	default:
+		return e.marshalComlpex(x)
+	}
+	return nil
 }
 
 func (e *Encoder) marshalComlpex(x interface{}) error {
-        p := reflect.ValueOf(x)
-        v := reflect.Indirect(p)
-        t := v.Type()
-        // for union
-        if u, ok := x.(Union); ok {
-                if len(u.Elem) <= u.Idx {
-                        return errors.New("union index error")
-                }
-                e.marshal(u.Idx)
-                return e.marshal(u.Elem[u.Idx])
-        }
-        // for enum use int instead
-        // for fixed use array
-        switch v.Kind() {
-        case reflect.Array:
-                if t.Elem().Kind() != reflect.Uint8 {
-                        return errors.New("element of array must be byte")
-                }
-                binary.Write(e.buf, binary.BigEndian, x)
-        // for array use slice
-        case reflect.Slice:
-                if v.Len() > 0 {
-                        e.marshal(v.Len())
-                        for i := 0; i < v.Len(); i++ {
-                                err := e.marshal(v.Index(i).Interface())
-                                if err != nil {
-                                        return err
-                                }
-                        }
-                }
-                e.marshal(0)
-        // for map
-        case reflect.Map:
-                if t.Key().Kind() != reflect.String {
-                        return errors.New("map key must be string")
-                }
-                if v.Len() > 0 {
-                        e.marshal(v.Len())
-                        keys := v.MapKeys()
-                        for _, k := range keys {
-                                e.marshal(k.Interface())
-                                err := e.marshal(v.MapIndex(k).Interface())
-                                if err != nil {
-                                        return err
-                                }
-                        }
-                }
-                e.marshal(0)
-        // for record
-        case reflect.Struct:
-                n := t.NumField()
-                for i := 0; i < n; i++ {
-                        // unexported
-                        if t.Field(i).PkgPath != "" {
-                                continue
-                        }
-                        record := v.Field(i)
-                        err := e.marshal(record.Interface())
-                        if err != nil {
-                                return err
-                        }
-                }
-        default:
-                panic(fmt.Errorf("not supported:%s", p.Type()))
-        }
-        return nil
+	p := reflect.ValueOf(x)
+	v := p
+	t := v.Type()
+	// for union
+	if u, ok := x.(Union); ok {
+		if len(u.Elem) <= u.Idx {
+			return errors.New("union index error")
+		}
+		elem, err := indirect(u.Elem[u.Idx])
+		if err != nil {
+			return err
+		}
+		e.buf = AppendLong(e.buf, int64(u.Idx))
+		return e.marshal(elem)
+	}
+	// for enum use int instead
+	// for fixed use array
+	switch v.Kind() {
+	case reflect.Array:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return errors.New("element of array must be byte")
+		}
+		n := len(e.buf)
+		e.buf = append(e.buf, make([]byte, v.Len())...)
+		reflect.Copy(reflect.ValueOf(e.buf[n:]), v)
+	// for array use slice
+	case reflect.Slice:
+		if v.Len() > 0 {
+			e.buf = AppendLong(e.buf, int64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				err := e.marshalValue(v.Index(i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		e.buf = AppendLong(e.buf, 0)
+	// for map
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return errors.New("map key must be string")
+		}
+		if v.Len() > 0 {
+			e.buf = AppendLong(e.buf, int64(v.Len()))
+			// sort the keys so the same map always encodes the same bytes
+			keys := v.MapKeys()
+			sort.Slice(keys, func(i, j int) bool {
+				return keys[i].String() < keys[j].String()
+			})
+			for _, k := range keys {
+				e.buf = AppendString(e.buf, k.String())
+				err := e.marshalValue(v.MapIndex(k))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		e.buf = AppendLong(e.buf, 0)
+	// for record
+	case reflect.Struct:
+		n := t.NumField()
+		for i := 0; i < n; i++ {
+			// unexported
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			var err error
+			record := v.Field(i)
+			_, opts := parseTag(t.Field(i).Tag.Get("avro"))
+			if record.Kind() == reflect.Ptr && opts.Contains("nullsecond") {
+				err = e.marshalNullable(record, true)
+			} else {
+				err = e.marshalValue(record)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		panic(fmt.Errorf("not supported:%s", p.Type()))
+	}
+	return nil
+}
+
+// marshalValue writes an element of a record, array or map, whose
+// static type may be a registered union.
+func (e *Encoder) marshalValue(v reflect.Value) error {
+	if v.Kind() == reflect.Interface {
+		if u := lookupUnion(v.Type()); u != nil {
+			return e.marshalTypedUnion(u, v)
+		}
+	}
+	return e.marshal(v.Interface())
+}
+
+// marshalNullable writes a pointer as a ["null", T] union,
+// or as a [T, "null"] union if nullSecond is set.
+func (e *Encoder) marshalNullable(v reflect.Value, nullSecond bool) error {
+	null, elem := 0, 1
+	if nullSecond {
+		null, elem = 1, 0
+	}
+	if v.IsNil() {
+		e.buf = AppendLong(e.buf, int64(null))
+		return nil
+	}
+	e.buf = AppendLong(e.buf, int64(elem))
+	return e.marshal(v.Elem().Interface())
+}
+
+// indirect returns the value x points to, so that a top level value
+// or union element may be passed either by value or by pointer.
+func indirect(x interface{}) (interface{}, error) {
+	v := reflect.ValueOf(x)
+	if v.Kind() != reflect.Ptr {
+		return x, nil
+	}
+	if v.IsNil() {
+		return nil, fmt.Errorf("nil pointer:%s", v.Type())
+	}
+	return v.Elem().Interface(), nil
 }
@@ -1,289 +1,425 @@
 package avro
 
 import (
-        "bytes"
-        "testing"
+	"bytes"
+	"math"
+	"testing"
 )
 
 func testEncodeSupport(t *testing.T, x interface{}) {
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-        err := enc.Encode(x)
-        if err != nil {
-                t.Error(t)
-        }
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode(x)
+	if err != nil {
+		t.Error(t)
+	}
 }
 
 func TestEncodeSupport(t *testing.T) {
-        // null
-        testEncodeSupport(t, Null(0))
+	// null
+	testEncodeSupport(t, Null(0))
 
-        // bool
-        testEncodeSupport(t, true)
+	// bool
+	testEncodeSupport(t, true)
 
-        // int long
-        testEncodeSupport(t, 1)
-        testEncodeSupport(t, int(1))
-        testEncodeSupport(t, int8(1))
-        testEncodeSupport(t, int16(1))
-        testEncodeSupport(t, int32(1))
-        testEncodeSupport(t, int64(1))
-        testEncodeSupport(t, uint(1))
-        testEncodeSupport(t, uint8(1))
-        testEncodeSupport(t, uint32(1))
-        testEncodeSupport(t, uint64(1))
+	// int long
+	testEncodeSupport(t, 1)
+	testEncodeSupport(t, int(1))
+	testEncodeSupport(t, int8(1))
+	testEncodeSupport(t, int16(1))
+	testEncodeSupport(t, int32(1))
+	testEncodeSupport(t, int64(1))
+	testEncodeSupport(t, uint(1))
+	testEncodeSupport(t, uint8(1))
+	testEncodeSupport(t, uint16(1))
+	testEncodeSupport(t, uint32(1))
+	testEncodeSupport(t, uint64(1))
 
-        // float double
-        testEncodeSupport(t, float32(1))
-        testEncodeSupport(t, float64(1))
+	// float double
+	testEncodeSupport(t, float32(1))
+	testEncodeSupport(t, float64(1))
 
-        // bytes
-        testEncodeSupport(t, []byte{})
+	// bytes
+	testEncodeSupport(t, []byte{})
 
-        // string
-        testEncodeSupport(t, "")
+	// string
+	testEncodeSupport(t, "")
 
-        // record
-        testEncodeSupport(t, struct{}{})
+	// record
+	testEncodeSupport(t, struct{}{})
 
-        // enum
-        testEncodeSupport(t, 1)
+	// enum
+	testEncodeSupport(t, 1)
 
-        // array
-        testEncodeSupport(t, []byte{1, 2, 3})
+	// array
+	testEncodeSupport(t, []byte{1, 2, 3})
 
-        // map
-        testEncodeSupport(t, map[string]string{})
+	// map
+	testEncodeSupport(t, map[string]string{})
 
-        // Unions
-        testEncodeSupport(t, Union{0, []interface{}{0}})
+	// Unions
+	testEncodeSupport(t, Union{0, []interface{}{0}})
 
-        // fixed
-        testEncodeSupport(t, [3]byte{1, 2, 3})
+	// fixed
+	testEncodeSupport(t, [3]byte{1, 2, 3})
 }
 
 func TestEncodeNil(t *testing.T) {
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-        enc.Encode(nil)
-        if buf.Len() != 0 {
-                t.Error("should 0")
-        }
-        var null Null
-        if err := enc.Encode(null); err != nil {
-                t.Error(err)
-        }
-
-        if buf.Len() != 0 {
-                t.Error("should 0")
-        }
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.Encode(nil)
+	if buf.Len() != 0 {
+		t.Error("should 0")
+	}
+	var null Null
+	if err := enc.Encode(null); err != nil {
+		t.Error(err)
+	}
+
+	if buf.Len() != 0 {
+		t.Error("should 0")
+	}
 }
 
 func TestEncodeInt(t *testing.T) {
-        m := map[int]byte{
-                0:  0,
-                -1: 1,
-                1:  2,
-                -2: 3,
-                2:  4,
-                -3: 5,
-                3:  6,
-        }
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-        for k, v := range m {
-                buf.Reset()
-                if err := enc.Encode(k); err != nil {
-                        t.Error(err)
-                        continue
-                }
-                if buf.Bytes()[0] != v {
-                        t.Error(buf.Bytes()[0])
-                }
-        }
+	m := map[int]byte{
+		0:  0,
+		-1: 1,
+		1:  2,
+		-2: 3,
+		2:  4,
+		-3: 5,
+		3:  6,
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for k, v := range m {
+		buf.Reset()
+		if err := enc.Encode(k); err != nil {
+			t.Error(err)
+			continue
+		}
+		if buf.Bytes()[0] != v {
+			t.Error(buf.Bytes()[0])
+		}
+	}
 }
 
 func TestEncodeBool(t *testing.T) {
-        m := map[bool]byte{
-                true:  1,
-                false: 0,
-        }
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-
-        for k, v := range m {
-                buf.Reset()
-                if err := enc.Encode(k); err != nil {
-                        t.Error(err)
-                        continue
-                }
-                if buf.Bytes()[0] != v {
-                        t.Error(buf.Bytes()[0])
-                }
-        }
+	m := map[bool]byte{
+		true:  1,
+		false: 0,
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	for k, v := range m {
+		buf.Reset()
+		if err := enc.Encode(k); err != nil {
+			t.Error(err)
+			continue
+		}
+		if buf.Bytes()[0] != v {
+			t.Error(buf.Bytes()[0])
+		}
+	}
 }
 
 func TestEncodeString(t *testing.T) {
-        m := map[string][]byte{
-                "foo": []byte{0x06, 0x66, 0x6f, 0x6f},
-        }
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-
-        for k, v := range m {
-                buf.Reset()
-                if err := enc.Encode(k); err != nil {
-                        t.Error(err)
-                        continue
-                }
-                if !bytes.Equal(buf.Bytes(), v) {
-                        t.Error(buf.Bytes())
-                }
-        }
+	m := map[string][]byte{
+		"foo": []byte{0x06, 0x66, 0x6f, 0x6f},
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	for k, v := range m {
+		buf.Reset()
+		if err := enc.Encode(k); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), v) {
+			t.Error(buf.Bytes())
+		}
+	}
 }
 
 func TestEncodeUnion(t *testing.T) {
-        u := Union{
-                Elem: []interface{}{
-                        Null(0),
-                        int(2),
-                        "foo",
-                },
-        }
-        m := map[int][]byte{
-                0: []byte{0},
-                1: []byte{2, 4},
-                2: []byte{0x04, 0x06, 0x66, 0x6f, 0x6f},
-        }
-
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-
-        for k, v := range m {
-                buf.Reset()
-                u.Idx = k
-                err := enc.Encode(u)
-                if err != nil {
-                        t.Error(err)
-                }
-                if !bytes.Equal(buf.Bytes(), v) {
-                        t.Error(buf.Bytes())
-                }
-        }
+	u := Union{
+		Elem: []interface{}{
+			Null(0),
+			int(2),
+			"foo",
+		},
+	}
+	m := map[int][]byte{
+		0: []byte{0},
+		1: []byte{2, 4},
+		2: []byte{0x04, 0x06, 0x66, 0x6f, 0x6f},
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	for k, v := range m {
+		buf.Reset()
+		u.Idx = k
+		err := enc.Encode(u)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(buf.Bytes(), v) {
+			t.Error(buf.Bytes())
+		}
+	}
 }
 
 func TestEncodeFixed(t *testing.T) {
-        m := map[string][]byte{
-                "foo": []byte{0x66, 0x6f, 0x6f},
-                "abc": []byte{0x61, 0x62, 0x63},
-        }
-        fix := [3]byte{}
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-
-        for k, v := range m {
-                buf.Reset()
-                copy(fix[:], k)
-                if err := enc.Encode(fix); err != nil {
-                        t.Error(err)
-                        continue
-                }
-                if !bytes.Equal(buf.Bytes(), v) {
-                        t.Error(buf.Bytes())
-                }
-        }
+	m := map[string][]byte{
+		"foo": []byte{0x66, 0x6f, 0x6f},
+		"abc": []byte{0x61, 0x62, 0x63},
+	}
+	fix := [3]byte{}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	for k, v := range m {
+		buf.Reset()
+		copy(fix[:], k)
+		if err := enc.Encode(fix); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), v) {
+			t.Error(buf.Bytes())
+		}
+	}
 }
 
 func TestEncodeArray(t *testing.T) {
-        m := [][]int{
-                []int{0, 1, 2, 3},
-        }
-        expect := [][]byte{
-                []byte{8, // length
-                        0, 2, 4, 6,
-                        0, // end
-                },
-        }
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-
-        for i, v := range m {
-                buf.Reset()
-                if err := enc.Encode(v); err != nil {
-                        t.Error(err)
-                        continue
-                }
-                if !bytes.Equal(buf.Bytes(), expect[i]) {
-                        t.Error(buf.Bytes())
-                }
-        }
+	m := [][]int{
+		[]int{0, 1, 2, 3},
+	}
+	expect := [][]byte{
+		[]byte{8, // length
+			0, 2, 4, 6,
+			0, // end
+		},
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	for i, v := range m {
+		buf.Reset()
+		if err := enc.Encode(v); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), expect[i]) {
+			t.Error(buf.Bytes())
+		}
+	}
 }
 
 func TestEncodeMap(t *testing.T) {
-        m := []map[string]string{
-                map[string]string{"abcd": "foo"},
-        }
-        expect := [][]byte{
-                []byte{0x02, // 1 key-value
-                        0x08,                   // key length
-                        0x61, 0x62, 0x63, 0x64, // key
-                        0x06,             // value length
-                        0x66, 0x6f, 0x6f, // value
-                        0x00, // end
-                },
-        }
-
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-
-        for i, v := range m {
-                buf.Reset()
-                if err := enc.Encode(v); err != nil {
-                        t.Error(err)
-                        continue
-                }
-
-                if !bytes.Equal(buf.Bytes(), expect[i]) {
-                        t.Error(buf.Bytes())
-                }
-        }
+	m := []map[string]string{
+		map[string]string{"abcd": "foo"},
+	}
+	expect := [][]byte{
+		[]byte{0x02, // 1 key-value
+			0x08,                   // key length
+			0x61, 0x62, 0x63, 0x64, // key
+			0x06,             // value length
+			0x66, 0x6f, 0x6f, // value
+			0x00, // end
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	for i, v := range m {
+		buf.Reset()
+		if err := enc.Encode(v); err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if !bytes.Equal(buf.Bytes(), expect[i]) {
+			t.Error(buf.Bytes())
+		}
+	}
 
 }
 
 func TestEncodeStruct(t *testing.T) {
-        m := struct {
-                Int    int
-                Nil    Null
-                skip   int
-                Fixed  [3]byte
-                String string
-                Map    map[string]string
-                Un     Union
-        }{
-                1,
-                Null(0),
-                2,
-                [3]byte{1, 2, 3},
-                "abc",
-                map[string]string{"a": "b"},
-                Union{1, []interface{}{Null(0), "a"}},
-        }
-        expect := []byte{
-                2, // int(1)
-                // Nil(skip)
-                // skip(skip)
-                1, 2, 3, // fixed
-                6, 0x61, 0x62, 0x63, // string
-                2, 2, 0x61, 2, 0x62, 0, // map
-                2, 2, 0x61, // union
-        }
-        buf := new(bytes.Buffer)
-        enc := NewEncoder(buf)
-
-        if err := enc.Encode(m); err != nil {
-                t.Error(err)
-        }
-        if !bytes.Equal(buf.Bytes(), expect) {
-                t.Error(buf.Bytes())
-        }
+	m := struct {
+		Int    int
+		Nil    Null
+		skip   int
+		Fixed  [3]byte
+		String string
+		Map    map[string]string
+		Un     Union
+	}{
+		1,
+		Null(0),
+		2,
+		[3]byte{1, 2, 3},
+		"abc",
+		map[string]string{"a": "b"},
+		Union{1, []interface{}{Null(0), "a"}},
+	}
+	expect := []byte{
+		2, // int(1)
+		// Nil(skip)
+		// skip(skip)
+		1, 2, 3, // fixed
+		6, 0x61, 0x62, 0x63, // string
+		2, 2, 0x61, 2, 0x62, 0, // map
+		2, 2, 0x61, // union
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	if err := enc.Encode(m); err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expect) {
+		t.Error(buf.Bytes())
+	}
+}
+
+func TestEncodeUintOverflow(t *testing.T) {
+	if _, err := Marshal(uint64(math.MaxInt64)); err != nil {
+		t.Error(err)
+	}
+	if _, err := Marshal(uint64(math.MaxInt64 + 1)); err == nil {
+		t.Error("should overflow long")
+	}
+}
+
+func TestEncodeMapOrder(t *testing.T) {
+	m := map[string]int{"c": 3, "a": 1, "b": 2}
+	expect := []byte{0x06,
+		0x02, 0x61, 0x02,
+		0x02, 0x62, 0x04,
+		0x02, 0x63, 0x06,
+		0x00,
+	}
+	for i := 0; i < 10; i++ {
+		b, err := Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, expect) {
+			t.Fatal(b)
+		}
+	}
+}
+
+type stable struct {
+	Null   Null
+	Bool   bool
+	Int    int32
+	Long   int64
+	Float  float32
+	Double float64
+	Bytes  []byte
+	String string
+	Fixed  [2]byte
+	Enum   suit
+	Array  []map[string]string
+	Map    map[string][]int
+	Ptr    *stable
+	Union  shape
+}
+
+func TestEncodeStable(t *testing.T) {
+	keys := "abcdefghijklmnopqrstuvwxyz"
+	v := stable{
+		Bool:   true,
+		Int:    -1,
+		Long:   1 << 40,
+		Float:  1.5,
+		Double: -2.5,
+		Bytes:  []byte{1, 2},
+		String: "abc",
+		Fixed:  [2]byte{3, 4},
+		Enum:   2,
+		Array:  []map[string]string{{}, {}},
+		Map:    map[string][]int{},
+		Ptr:    &stable{Map: map[string][]int{"x": {1}}},
+		Union:  circle{1},
+	}
+	for i := range keys {
+		k := keys[i : i+1]
+		v.Array[0][k] = k
+		v.Array[1][k+k] = k
+		v.Map[k] = []int{i}
+	}
+
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		b1, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, b1) {
+			t.Fatal("encoding is not deterministic")
+		}
+	}
+}
+
+func TestAppendMarshal(t *testing.T) {
+	v := record{1, Null(0), 2, [3]byte{1, 2, 3}, "abc"}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := []byte{0xff}
+	dst, err = AppendMarshal(dst, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst[0] != 0xff || !bytes.Equal(dst[1:], b) {
+		t.Error(dst)
+	}
+	if _, err := AppendMarshal(dst, []uint64{math.MaxUint64}); err == nil {
+		t.Error("should overflow long")
+	}
+
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		AppendMarshal(buf[:0], &v)
+	})
+	if allocs > 1 {
+		t.Error("AppendMarshal allocates", allocs)
+	}
+}
+
+func TestAppendPrimitive(t *testing.T) {
+	var b []byte
+	b = AppendBool(b, true)
+	b = AppendInt(b, -2)
+	b = AppendLong(b, 64)
+	b = AppendFloat(b, 1)
+	b = AppendDouble(b, 2)
+	b = AppendBytes(b, []byte{1})
+	b = AppendString(b, "a")
+	expect := []byte{
+		1,
+		3,
+		0x80, 0x01,
+		0x00, 0x00, 0x80, 0x3f,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40,
+		2, 1,
+		2, 0x61,
+	}
+	if !bytes.Equal(b, expect) {
+		t.Error(b)
+	}
 }
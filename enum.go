@@ -0,0 +1,96 @@
+package avro
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Enum is implemented by enum types. The type is either an integer type
+// holding the ordinal of the symbol, or a string type holding the symbol
+// itself. Symbols returns the symbols in schema order and must be
+// declared on the value receiver.
+type Enum interface {
+	Symbols() []string
+}
+
+// EnumDefaulter is implemented by enums which have a default symbol.
+// Following schema resolution, the default replaces a symbol the reader
+// does not know, i.e. an ordinal beyond its symbols.
+type EnumDefaulter interface {
+	Enum
+	Default() string
+}
+
+func enumIndex(symbols []string, s string) int {
+	for i, sym := range symbols {
+		if sym == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *Encoder) marshalEnum(x Enum) error {
+	symbols := x.Symbols()
+	v := reflect.ValueOf(x)
+	var idx int
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n < 0 || n >= int64(len(symbols)) {
+			return fmt.Errorf("enum %s ordinal out of range:%d", v.Type(), n)
+		}
+		idx = int(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := v.Uint()
+		if n >= uint64(len(symbols)) {
+			return fmt.Errorf("enum %s ordinal out of range:%d", v.Type(), n)
+		}
+		idx = int(n)
+	case reflect.String:
+		idx = enumIndex(symbols, v.String())
+		if idx == -1 {
+			return fmt.Errorf("enum %s unknown symbol:%q", v.Type(), v.String())
+		}
+	default:
+		return fmt.Errorf("enum must be integer or string:%s", v.Type())
+	}
+	e.buf = AppendLong(e.buf, int64(idx))
+	return nil
+}
+
+// decodeEnum reads an enum into x, which must be a pointer.
+func (d *Decoder) decodeEnum(x Enum) error {
+	v := reflect.ValueOf(x)
+	if v.Kind() != reflect.Ptr {
+		return fmt.Errorf("decodeEnum need ptr:%s", v.Type())
+	}
+	v = v.Elem()
+	symbols := x.Symbols()
+
+	n, err := d.readInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= int64(len(symbols)) {
+		def, ok := x.(EnumDefaulter)
+		if !ok {
+			return fmt.Errorf("enum %s ordinal out of range:%d", v.Type(), n)
+		}
+		n = int64(enumIndex(symbols, def.Default()))
+		if n == -1 {
+			return fmt.Errorf("enum %s unknown default:%q", v.Type(), def.Default())
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return setInt(v, n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return setUint(v, n)
+	case reflect.String:
+		v.SetString(symbols[n])
+		return nil
+	}
+	return fmt.Errorf("enum must be integer or string:%s", v.Type())
+}
@@ -0,0 +1,101 @@
+package avro
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type suit int
+
+func (suit) Symbols() []string {
+	return []string{"SPADES", "HEARTS", "DIAMONDS", "CLUBS"}
+}
+
+type color string
+
+func (color) Symbols() []string {
+	return []string{"RED", "GREEN", "BLUE"}
+}
+
+type colorDefault string
+
+func (colorDefault) Symbols() []string {
+	return []string{"RED", "GREEN", "UNKNOWN"}
+}
+
+func (colorDefault) Default() string {
+	return "UNKNOWN"
+}
+
+func TestEnum(t *testing.T) {
+	RunCase(t, []suit{0, 1, 2, 3})
+	RunCase(t, []color{"RED", "GREEN", "BLUE"})
+
+	b, err := Marshal(color("BLUE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{4}) {
+		t.Error(b)
+	}
+	var s suit
+	if err := Unmarshal(b, &s); err != nil || s != 2 {
+		t.Error(s, err)
+	}
+	var c color
+	if err := Unmarshal(b, &c); err != nil || c != "BLUE" {
+		t.Error(c, err)
+	}
+}
+
+func TestEnumRange(t *testing.T) {
+	if _, err := Marshal(suit(4)); err == nil {
+		t.Error("ordinal 4 is out of range")
+	}
+	if _, err := Marshal(color("PINK")); err == nil {
+		t.Error("PINK is not a symbol")
+	}
+
+	b, _ := Marshal(6)
+	var s suit
+	if err := Unmarshal(b, &s); err == nil {
+		t.Error("ordinal 6 is out of range")
+	}
+	var c colorDefault
+	if err := Unmarshal(b, &c); err != nil || c != "UNKNOWN" {
+		t.Error(c, err)
+	}
+}
+
+func TestEnumPointer(t *testing.T) {
+	type hand struct {
+		Suit  *suit
+		Color *color
+	}
+	s, c := suit(2), color("GREEN")
+	for _, in := range []hand{{&s, &c}, {nil, nil}} {
+		b, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := SchemaOf(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateDatum(schema, in); err != nil {
+			t.Error(err)
+		}
+		var out hand
+		if err := Unmarshal(b, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Error(out)
+		}
+	}
+	b, _ := Marshal(hand{&s, nil})
+	if !bytes.Equal(b, []byte{2, 4, 0}) {
+		t.Error(b)
+	}
+}
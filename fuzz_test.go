@@ -0,0 +1,256 @@
+package avro
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+type nested struct {
+	Flat   flat
+	Flats  []*flat
+	ByName map[string][]int32
+	Small  int8
+	Short  uint16
+	Float  float32
+	Hash   [4]byte
+	Suit   suit
+	Next   *nested
+}
+
+// fuzzTargets returns new values of the types the fuzz target decodes
+// into.
+func fuzzTargets() []interface{} {
+	return []interface{}{
+		new(flat),
+		new(nested),
+		new(nullable),
+		new(drawing),
+		new([]string),
+		new([]struct{}),
+		new([]Null),
+		new(map[string]int64),
+		&Union{Elem: []interface{}{new(Null), new(int32), new(string)}},
+	}
+}
+
+// fuzzMaxItems bounds the items without data the fuzz targets read, so
+// that inputs run fast.
+const fuzzMaxItems = 100
+
+func FuzzDecode(f *testing.F) {
+	n := 3
+	seeds := []interface{}{
+		flat{Int: 1, Bool: true, Double: 1.5, Fixed: [3]byte{1, 2, 3}, Bytes: []byte{4}, String: "a"},
+		nested{Flats: []*flat{nil, {Int: 2}}, ByName: map[string][]int32{"a": {1, 2}}, Next: &nested{Suit: 2}},
+		nullable{Int: &n, String: new(string), Ints: []*int{nil, &n}},
+		drawing{Shape: circle{1}, Shapes: []shape{nil, &square{2}, "b"}},
+		[]string{"a", "b"},
+		map[string]int64{"a": 1},
+		MakeUnion(2, new(Null), new(int32), new(string)),
+	}
+	for _, x := range seeds {
+		b, err := Marshal(x)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01})
+	f.Add([]byte{0xfe, 0xff, 0xff, 0xff, 0x0f})
+	// a block of 1<<31-1 items reading no bytes
+	f.Add([]byte{0xfe, 0xff, 0xff, 0xff, 0x0f, 0x00})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for _, x := range fuzzTargets() {
+			// errors are expected, panics are not
+			d := NewBytesDecoder(b)
+			d.SetMaxItems(fuzzMaxItems)
+			d.Decode(x)
+			d = NewDecoder(bytes.NewReader(b))
+			d.SetMaxItems(fuzzMaxItems)
+			d.Decode(x)
+		}
+	})
+}
+
+func FuzzReadGeneric(f *testing.F) {
+	e := NewEncoder(nil)
+	if err := e.WriteGeneric(nodeSchema, nodeValue); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(e.buf)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		d := NewBytesDecoder(b)
+		d.SetMaxItems(fuzzMaxItems)
+		v, err := d.ReadGeneric(nodeSchema)
+		if err != nil {
+			return
+		}
+		// whatever decodes must encode again
+		e := NewEncoder(nil)
+		if err := e.WriteGeneric(nodeSchema, v); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestDecodeLengthAllocation(t *testing.T) {
+	// a length of 1<<40 followed by a few bytes
+	b := AppendLong(nil, 1<<40)
+	b = append(b, "abc"...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var s string
+	if err := NewDecoder(bytes.NewReader(b)).Decode(&s); err == nil {
+		t.Error("length beyond the input")
+	}
+	var bs []byte
+	if err := Unmarshal(b, &bs); err == nil {
+		t.Error("length beyond the input")
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes", n)
+	}
+}
+
+func TestDecodeEmptyItems(t *testing.T) {
+	b := AppendLong(nil, math.MaxInt32)
+	b = append(b, 0)
+	start := time.Now()
+	for _, x := range []interface{}{new([]struct{}), new([]Null), new(map[Null]Null)} {
+		if err := Unmarshal(b, x); err == nil {
+			t.Errorf("%T: no error", x)
+		}
+	}
+	array := MustParseSchema(`{"type": "array", "items": "null"}`)
+	if _, err := NewBytesDecoder(b).ReadGeneric(array); err == nil {
+		t.Error("no error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Error("took", d)
+	}
+
+	// the limit is configurable, and items reading data are not limited
+	d := NewBytesDecoder([]byte{20, 0})
+	d.SetMaxItems(10)
+	if _, err := d.ReadGeneric(array); err != nil {
+		t.Error(err)
+	}
+	d = NewBytesDecoder([]byte{40, 0})
+	d.SetMaxItems(10)
+	if _, err := d.ReadGeneric(array); err == nil {
+		t.Error("over the limit")
+	}
+	// the limit is for all the items read until a reset, nested or not
+	d = NewBytesDecoder([]byte{20, 0, 20, 0})
+	d.SetMaxItems(10)
+	if _, err := d.ReadGeneric(array); err != nil {
+		t.Error(err)
+	}
+	if _, err := d.ReadGeneric(array); err == nil {
+		t.Error("over the limit")
+	}
+	d.ResetBytes([]byte{20, 0})
+	if _, err := d.ReadGeneric(array); err != nil {
+		t.Error(err)
+	}
+	d = NewBytesDecoder([]byte{6, 10, 0, 10, 0, 10, 0, 0})
+	d.SetMaxItems(10)
+	var nested [][]Null
+	if err := d.Decode(&nested); err == nil {
+		t.Error("over the limit")
+	}
+	ints := make([]int32, 100)
+	b, _ = Marshal(ints)
+	d = NewBytesDecoder(b)
+	d.SetMaxItems(10)
+	var out []int32
+	if err := d.Decode(&out); err != nil || len(out) != 100 {
+		t.Error(len(out), err)
+	}
+}
+
+func TestRoundTripProperty(t *testing.T) {
+	roundTrip := func(x interface{}) bool {
+		b, err := Marshal(x)
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+		out := reflect.New(reflect.TypeOf(x))
+		if err := Unmarshal(b, out.Interface()); err != nil {
+			t.Error(err)
+			return false
+		}
+		return reflect.DeepEqual(out.Elem().Interface(), x)
+	}
+	properties := []interface{}{
+		func(x bool, y int, z int64, f float64, s string) bool {
+			return roundTrip(x) && roundTrip(y) && roundTrip(z) && roundTrip(f) && roundTrip(s)
+		},
+		func(x []byte, y []string, z map[string]float32) bool {
+			return roundTrip(x) && roundTrip(y) && roundTrip(z)
+		},
+		func(x flat) bool { return roundTrip(x) },
+		func(x nested) bool { return roundTrip(x) },
+		func(x map[string][]*nested) bool { return roundTrip(x) },
+	}
+	for _, p := range properties {
+		if err := quick.Check(p, nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRoundTripUnionProperty(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	shapeOf := func() shape {
+		switch rng.Intn(4) {
+		case 0:
+			return nil
+		case 1:
+			return circle{rng.Int()}
+		case 2:
+			return &square{rng.Int()}
+		}
+		return randomString(rng)
+	}
+	for i := 0; i < 100; i++ {
+		in := drawing{Shape: shapeOf(), Named: map[string]shape{}}
+		for j := rng.Intn(5); j > 0; j-- {
+			in.Shapes = append(in.Shapes, shapeOf())
+			in.Named[randomString(rng)] = shapeOf()
+		}
+		b, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out drawing
+		if err := Unmarshal(b, &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(in.Shapes) == 0 {
+			in.Shapes = []shape{}
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("%#v != %#v", out, in)
+		}
+	}
+}
+
+// Generate implements quick.Generator, as suit must be a valid ordinal.
+func (suit) Generate(rng *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(suit(rng.Intn(4)))
+}
+
+func randomString(rng *rand.Rand) string {
+	v, _ := quick.Value(reflect.TypeOf(""), rng)
+	return v.String()
+}
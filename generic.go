@@ -0,0 +1,406 @@
+package avro
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// The generic representation of data, read and written by schema instead
+// of by Go type:
+//
+//      null        nil
+//      boolean     bool
+//      int, long   int32, int64
+//      float       float32
+//      double      float64
+//      bytes       []byte
+//      string      string
+//      record      map[string]interface{} keyed by field name
+//      enum        string symbol
+//      array       []interface{}
+//      map         map[string]interface{}
+//      union       the value of the branch, or a Branch holding it
+//      fixed       []byte
+//
+// When writing, any Go integer or float may be given for a number. The
+// branch of a union is the first one of the type read, e.g. double for a
+// float64, or else the first one which can hold the value. A value read
+// from another branch, e.g. a record from a union whose map branch comes
+// first, is read as a Branch naming it.
+
+// Branch is the generic value of a union whose branch is not the one
+// ResolveUnion finds for Value. Name is the name of the branch as in
+// JSON: the type, or the name of a named type.
+type Branch struct {
+	Name  string
+	Value interface{}
+}
+
+// MakeBranch returns the generic value of the branch idx of the union s
+// holding v: v itself if ResolveUnion finds that branch for it, or else a
+// Branch.
+func MakeBranch(s *Schema, idx int, v interface{}) interface{} {
+	if ResolveUnion(s, v) == idx {
+		return v
+	}
+	return Branch{typeName(s.Branches[idx]), v}
+}
+
+// ReadGeneric reads a value of schema s in its generic representation.
+func (d *Decoder) ReadGeneric(s *Schema) (interface{}, error) {
+	switch s.Type {
+	case TypeNull:
+		return nil, nil
+	case TypeBoolean:
+		return d.ReadBool()
+	case TypeInt:
+		return d.ReadInt()
+	case TypeLong:
+		return d.ReadLong()
+	case TypeFloat:
+		return d.ReadFloat()
+	case TypeDouble:
+		return d.ReadDouble()
+	case TypeBytes:
+		return d.ReadBytes()
+	case TypeString:
+		return d.ReadString()
+	case TypeRecord:
+		m := make(map[string]interface{}, len(s.Fields))
+		for _, f := range s.Fields {
+			v, err := d.ReadGeneric(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("decode %s:%s", f.Name, err)
+			}
+			m[f.Name] = v
+		}
+		return m, nil
+	case TypeEnum:
+		n, err := d.ReadInt()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || int(n) >= len(s.Symbols) {
+			if s.EnumDefault != "" {
+				return s.EnumDefault, nil
+			}
+			return nil, fmt.Errorf("enum %s ordinal out of range:%d", s.Name, n)
+		}
+		return s.Symbols[n], nil
+	case TypeArray:
+		a := make([]interface{}, 0)
+		items := d.countItems()
+		n, err := d.ReadArrayStart()
+		for ; n > 0 && err == nil; n, err = d.ReadArrayNext() {
+			for i := 0; i < n; i++ {
+				if err := items.next(); err != nil {
+					return nil, err
+				}
+				v, err := d.ReadGeneric(s.Items)
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, v)
+			}
+		}
+		return a, err
+	case TypeMap:
+		m := make(map[string]interface{})
+		items := d.countItems()
+		n, err := d.ReadMapStart()
+		for ; n > 0 && err == nil; n, err = d.ReadMapNext() {
+			for i := 0; i < n; i++ {
+				if err := items.next(); err != nil {
+					return nil, err
+				}
+				k, err := d.ReadString()
+				if err != nil {
+					return nil, err
+				}
+				v, err := d.ReadGeneric(s.Values)
+				if err != nil {
+					return nil, err
+				}
+				m[k] = v
+			}
+		}
+		return m, err
+	case TypeUnion:
+		idx, err := d.ReadUnionIndex()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= len(s.Branches) {
+			return nil, fmt.Errorf("union index error:%d", idx)
+		}
+		v, err := d.ReadGeneric(s.Branches[idx])
+		if err != nil {
+			return nil, err
+		}
+		return MakeBranch(s, idx, v), nil
+	case TypeFixed:
+		b := make([]byte, s.Size)
+		return b, d.ReadFixed(b)
+	}
+	return nil, fmt.Errorf("not supported:%s", s.Type)
+}
+
+// WriteGeneric writes v, in the generic representation, as a value of
+// schema s without flushing.
+func (e *Encoder) WriteGeneric(s *Schema, v interface{}) error {
+	switch s.Type {
+	case TypeNull:
+		if v != nil {
+			return fmt.Errorf("%T for null", v)
+		}
+	case TypeBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("%T for boolean", v)
+		}
+		e.WriteBool(b)
+	case TypeInt, TypeLong:
+		n, ok := genericInt(v)
+		if !ok {
+			return fmt.Errorf("%T for %s", v, s.Type)
+		}
+		if s.Type == TypeInt && (n < math.MinInt32 || n > math.MaxInt32) {
+			return fmt.Errorf("value %d overflows int", n)
+		}
+		e.WriteLong(n)
+	case TypeFloat, TypeDouble:
+		f, ok := genericFloat(v)
+		if !ok {
+			return fmt.Errorf("%T for %s", v, s.Type)
+		}
+		if s.Type == TypeFloat {
+			e.WriteFloat(float32(f))
+		} else {
+			e.WriteDouble(f)
+		}
+	case TypeBytes, TypeString:
+		switch v := v.(type) {
+		case []byte:
+			e.WriteBytes(v)
+		case string:
+			e.WriteString(v)
+		default:
+			return fmt.Errorf("%T for %s", v, s.Type)
+		}
+	case TypeRecord:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%T for record %s", v, s.Name)
+		}
+		for _, f := range s.Fields {
+			fv, ok := m[f.Name]
+			if !ok {
+				return fmt.Errorf("record %s missing field:%s", s.Name, f.Name)
+			}
+			if err := e.WriteGeneric(f.Type, fv); err != nil {
+				return fmt.Errorf("encode %s:%s", f.Name, err)
+			}
+		}
+	case TypeEnum:
+		sym, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%T for enum %s", v, s.Name)
+		}
+		idx := enumIndex(s.Symbols, sym)
+		if idx == -1 {
+			return fmt.Errorf("enum %s unknown symbol:%q", s.Name, sym)
+		}
+		e.WriteInt(int32(idx))
+	case TypeArray:
+		a, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%T for array", v)
+		}
+		e.WriteArrayStart(len(a))
+		for _, item := range a {
+			if err := e.WriteGeneric(s.Items, item); err != nil {
+				return err
+			}
+		}
+		e.WriteArrayEnd()
+	case TypeMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%T for map", v)
+		}
+		e.WriteMapStart(len(m))
+		for _, k := range sortedKeys(m) {
+			e.WriteString(k)
+			if err := e.WriteGeneric(s.Values, m[k]); err != nil {
+				return err
+			}
+		}
+		e.WriteMapEnd()
+	case TypeUnion:
+		idx := ResolveUnion(s, v)
+		if idx == -1 {
+			return unionError(v)
+		}
+		if b, ok := v.(Branch); ok {
+			v = b.Value
+		}
+		e.WriteUnionIndex(idx)
+		return e.WriteGeneric(s.Branches[idx], v)
+	case TypeFixed:
+		b, ok := v.([]byte)
+		if !ok || len(b) != s.Size {
+			return fmt.Errorf("%T of wrong size for fixed %s", v, s.Name)
+		}
+		e.WriteFixed(b)
+	default:
+		return fmt.Errorf("not supported:%s", s.Type)
+	}
+	return nil
+}
+
+// ResolveUnion returns the index of the branch of the union s for the
+// generic value v: the branch named by a Branch, else the first branch
+// whose type reads the Go type of v, so that values read are written back
+// in the same branch, or else the first which can hold v. It returns -1
+// if no branch can.
+func ResolveUnion(s *Schema, v interface{}) int {
+	if b, ok := v.(Branch); ok {
+		return branchIndex(s, b.Name)
+	}
+	for i, b := range s.Branches {
+		if genericExact(b, v) {
+			return i
+		}
+	}
+	for i, b := range s.Branches {
+		if genericMatches(b, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// branchIndex returns the index of the branch of the union s named name,
+// which may be the short name of a named type, or -1.
+func branchIndex(s *Schema, name string) int {
+	for i, b := range s.Branches {
+		if typeName(b) == name || (b.isNamed() && strings.HasSuffix(b.Name, "."+name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func unionError(v interface{}) error {
+	if b, ok := v.(Branch); ok {
+		return fmt.Errorf("union has no branch %s", b.Name)
+	}
+	return fmt.Errorf("%T matches no union branch", v)
+}
+
+// genericExact reports whether v has the Go type ReadGeneric reads for s.
+func genericExact(s *Schema, v interface{}) bool {
+	switch s.Type {
+	case TypeInt:
+		_, ok := v.(int32)
+		return ok
+	case TypeLong:
+		_, ok := v.(int64)
+		return ok
+	case TypeFloat:
+		_, ok := v.(float32)
+		return ok
+	case TypeDouble:
+		_, ok := v.(float64)
+		return ok
+	}
+	return genericMatches(s, v)
+}
+
+func genericMatches(s *Schema, v interface{}) bool {
+	switch s.Type {
+	case TypeNull:
+		return v == nil
+	case TypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case TypeInt:
+		n, ok := genericInt(v)
+		return ok && n >= math.MinInt32 && n <= math.MaxInt32
+	case TypeLong:
+		_, ok := genericInt(v)
+		return ok
+	case TypeFloat, TypeDouble:
+		switch v.(type) {
+		case float32, float64:
+			return true
+		}
+		return false
+	case TypeBytes:
+		_, ok := v.([]byte)
+		return ok
+	case TypeString:
+		_, ok := v.(string)
+		return ok
+	case TypeRecord:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, f := range s.Fields {
+			if _, ok := m[f.Name]; !ok {
+				return false
+			}
+		}
+		return true
+	case TypeEnum:
+		sym, ok := v.(string)
+		return ok && enumIndex(s.Symbols, sym) != -1
+	case TypeArray:
+		_, ok := v.([]interface{})
+		return ok
+	case TypeMap:
+		_, ok := v.(map[string]interface{})
+		return ok
+	case TypeFixed:
+		b, ok := v.([]byte)
+		return ok && len(b) == s.Size
+	}
+	return false
+}
+
+func genericInt(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		return int64(u), u <= math.MaxInt64
+	}
+	return 0, false
+}
+
+func genericFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	if n, ok := genericInt(v); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
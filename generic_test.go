@@ -0,0 +1,189 @@
+package avro
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var nodeSchema = MustParseSchema(`{"type": "record", "name": "ns.Node", "fields": [
+        {"name": "id", "type": "long"},
+        {"name": "tag", "type": ["null", "string"], "default": null},
+        {"name": "raw", "type": {"type": "fixed", "name": "F", "size": 2}},
+        {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}, "default": "B"},
+        {"name": "attrs", "type": {"type": "map", "values": "double"}, "default": {}},
+        {"name": "next", "type": ["null", "Node"], "default": null}
+]}`)
+
+var nodeValue = map[string]interface{}{
+	"id":    int64(1),
+	"tag":   "a",
+	"raw":   []byte{0xff, 0},
+	"kind":  "A",
+	"attrs": map[string]interface{}{"x": 1.5},
+	"next": map[string]interface{}{
+		"id":    int64(2),
+		"tag":   nil,
+		"raw":   []byte{1, 2},
+		"kind":  "B",
+		"attrs": map[string]interface{}{},
+		"next":  nil,
+	},
+}
+
+func TestGenericRoundTrip(t *testing.T) {
+	e := NewEncoder(nil)
+	if err := e.WriteGeneric(nodeSchema, nodeValue); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewBytesDecoder(e.buf).ReadGeneric(nodeSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, nodeValue) {
+		t.Errorf("%v != %v", v, nodeValue)
+	}
+}
+
+func TestGenericStruct(t *testing.T) {
+	// the generic encoding is the encoding of the matching struct
+	n := 1
+	x := nullable{Int: &n, String: new(string), Ints: []*int{nil, &n}}
+	s, err := SchemaOf(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewBytesDecoder(b).ReadGeneric(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEncoder(nil)
+	if err := e.WriteGeneric(s, v); err != nil {
+		t.Fatal(err)
+	}
+	if string(e.buf) != string(b) {
+		t.Errorf("%x != %x", e.buf, b)
+	}
+}
+
+func TestResolveUnion(t *testing.T) {
+	s := MustParseSchema(`["null", "int", "long", "double", "string", {"type": "fixed", "name": "F", "size": 1}, "bytes"]`)
+	cases := []struct {
+		v   interface{}
+		idx int
+	}{
+		{nil, 0},
+		{int32(1), 1},
+		{1 << 40, 2},
+		{float32(1), 3},
+		{"a", 4},
+		{[]byte{1}, 5},
+		{[]byte{1, 2}, 6},
+		{true, -1},
+	}
+	for _, c := range cases {
+		if idx := ResolveUnion(s, c.v); idx != c.idx {
+			t.Errorf("%#v: branch %d, expect %d", c.v, idx, c.idx)
+		}
+	}
+}
+
+const mapOrRecord = `[{"type": "map", "values": "string"},
+        {"type": "record", "name": "R", "fields": [{"name": "a", "type": "string"}]}]`
+
+func TestGenericUnionBranch(t *testing.T) {
+	cases := []struct {
+		schema string
+		v      interface{}
+	}{
+		{`["float", "double"]`, float64(1.1)},
+		{`["float", "double"]`, float32(1.1)},
+		{`["double", "float"]`, float32(1.1)},
+		{`["int", "long"]`, int64(3)},
+		{`["long", "int"]`, int32(3)},
+		{`["int", "long", "float", "double"]`, float64(-2.5)},
+		{`["null", "int", "double", "long"]`, int64(1) << 40},
+		{`{"type": "array", "items": ["int", "long", "float", "double"]}`,
+			[]interface{}{int32(1), int64(2), float32(3), float64(4)}},
+		{mapOrRecord, map[string]interface{}{"a": "x"}},
+		{mapOrRecord, Branch{"R", map[string]interface{}{"a": "x"}}},
+		{`["bytes", {"type": "fixed", "name": "F", "size": 2}]`, Branch{"F", []byte{1, 2}}},
+		{`["string", {"type": "enum", "name": "E", "symbols": ["a"]}]`, Branch{"E", "a"}},
+		{`["string", {"type": "enum", "name": "E", "symbols": ["a"]}]`, "a"},
+		{`[{"type": "record", "name": "A", "fields": [{"name": "x", "type": "int"}]},
+                   {"type": "record", "name": "ns.B", "fields": [{"name": "x", "type": "int"}]}]`,
+			Branch{"ns.B", map[string]interface{}{"x": int32(1)}}},
+	}
+	for _, c := range cases {
+		s := MustParseSchema(c.schema)
+		e := NewEncoder(nil)
+		if err := e.WriteGeneric(s, c.v); err != nil {
+			t.Fatal(err)
+		}
+		b := append([]byte(nil), e.buf...)
+		v, err := NewBytesDecoder(b).ReadGeneric(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, c.v) {
+			t.Errorf("%s: %#v read as %#v", c.schema, c.v, v)
+		}
+		e = NewEncoder(nil)
+		if err := e.WriteGeneric(s, v); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(e.buf, b) {
+			t.Errorf("%s: %x written as %x", c.schema, b, e.buf)
+		}
+		j, err := ToJSON(s, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jv, err := FromJSON(s, j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(jv, c.v) {
+			t.Errorf("%s: %#v through %s is %#v", c.schema, c.v, j, jv)
+		}
+	}
+}
+
+func TestGenericBranchRead(t *testing.T) {
+	s := MustParseSchema(mapOrRecord)
+	v, err := NewBytesDecoder([]byte{2, 2, 'x'}).ReadGeneric(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, Branch{"R", map[string]interface{}{"a": "x"}}) {
+		t.Errorf("%#v", v)
+	}
+	e := NewEncoder(nil)
+	if err := e.WriteGeneric(s, Branch{"R", map[string]interface{}{"a": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(e.buf, []byte{2, 2, 'x'}) {
+		t.Errorf("%x", e.buf)
+	}
+	if err := NewEncoder(nil).WriteGeneric(s, Branch{"S", nil}); err == nil {
+		t.Error("unknown branch")
+	}
+	if err := NewEncoder(nil).WriteGeneric(s, Branch{"R", "x"}); err == nil {
+		t.Error("string for record")
+	}
+
+	// a short name selects a named branch
+	s = MustParseSchema(`[{"type": "record", "name": "ns.A", "fields": []},
+                {"type": "record", "name": "ns.B", "fields": []}]`)
+	e = NewEncoder(nil)
+	if err := e.WriteGeneric(s, Branch{"B", map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(e.buf, []byte{2}) {
+		t.Errorf("%x", e.buf)
+	}
+}
@@ -0,0 +1,334 @@
+package ipc
+
+import (
+	"avro"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by calls of a closed Client.
+var ErrClosed = errors.New("client closed")
+
+// ConnError is the error of calls failed by their connection rather than
+// by the server.
+type ConnError struct {
+	Err error
+}
+
+func (e *ConnError) Error() string {
+	return "connection error:" + e.Err.Error()
+}
+
+// Client calls the messages of a protocol over a connection. Unlike
+// rpc.Client it takes a context per call, and returns errors of the
+// server as RemoteError, registered error types or *ErrorRecord. Calls
+// may be made concurrently.
+type Client struct {
+	conn     io.ReadWriteCloser
+	protocol *Protocol
+
+	interceptors []ClientInterceptor
+	invoker      Invoker // invoke through the interceptors
+
+	handShakeOnce sync.Once
+	handShakeDone chan struct{} // closed when the handshake is done
+	handShakeOK   bool          // set before handShakeDone is closed
+	handShakeMeta map[string]string
+	maxFrameSize  int
+	serverMeta    map[string]string // of the handshake response
+	wmu           sync.Mutex        // guards writes to conn
+
+	mu      sync.Mutex
+	seq     int32
+	pending map[int32]*call
+	err     error // set once the client is broken or closed
+}
+
+type call struct {
+	msg  *Message
+	done chan callResult
+}
+
+// callResult is the response of a call: its metadata, and an error or the
+// decoder of the response value.
+type callResult struct {
+	meta map[string]string
+	d    *avro.Decoder
+	err  error
+}
+
+// NewClient returns a Client calling the messages of p on conn. The
+// handshake is done by the first call.
+func NewClient(conn io.ReadWriteCloser, p *Protocol) *Client {
+	c := &Client{
+		conn:          conn,
+		protocol:      p,
+		handShakeDone: make(chan struct{}),
+		pending:       make(map[int32]*call),
+		maxFrameSize:  DefaultMaxFrameSize,
+	}
+	c.invoker = c.invoke
+	return c
+}
+
+// Use adds interceptors around the calls of c. The first interceptor
+// added is the outermost. It must be called before the first call.
+func (c *Client) Use(interceptors ...ClientInterceptor) {
+	c.interceptors = append(c.interceptors, interceptors...)
+	c.invoker = c.invoke
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		c.invoker = c.interceptors[i].wrap(c.invoker)
+	}
+}
+
+// SetHandShakeMeta sets the metadata sent in the handshake. It must be
+// called before the first call.
+func (c *Client) SetHandShakeMeta(meta map[string]string) {
+	c.handShakeMeta = meta
+}
+
+// SetMaxFrameSize sets the size of the largest response read,
+// DefaultMaxFrameSize by default. A larger response breaks the client, and
+// its call fails with ErrFrameTooLarge in a *ConnError. It must be called
+// before the first call.
+func (c *Client) SetMaxFrameSize(n int) {
+	c.maxFrameSize = n
+}
+
+// HandShakeMeta returns the metadata of the handshake response of the
+// server, once a call has been made.
+func (c *Client) HandShakeMeta() map[string]string {
+	select {
+	case <-c.handShakeDone:
+		return c.serverMeta
+	default:
+		return nil
+	}
+}
+
+// Call calls message with the request req, a struct of the parameters of
+// the message, and decodes the response into resp, which may be nil to
+// discard it. Call returns when the response arrives, or right after
+// sending for one-way messages, or when ctx is done, in which case the
+// response is discarded when it arrives. Calls pending when the
+// connection fails get a *ConnError.
+//
+// The request carries the metadata of ctx set with WithMeta, and the
+// metadata of the response is stored as set with WithResponseMeta.
+//
+// req may also be a map of the parameters in the generic representation,
+// and resp an *interface{} to store the response in, as by CallGeneric.
+func (c *Client) Call(ctx context.Context, message string, req, resp interface{}) error {
+	return c.invoker(ctx, message, req, resp)
+}
+
+// invoke makes a call without the interceptors.
+func (c *Client) invoke(ctx context.Context, message string, req, resp interface{}) error {
+	m := c.protocol.Messages[message]
+	if m == nil {
+		return fmt.Errorf("unknown message:%s", message)
+	}
+	c.handShakeOnce.Do(func() {
+		go c.handShake()
+	})
+	select {
+	case <-c.handShakeDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	f := new(Frame)
+	e := avro.NewEncoder(f)
+	payload := req
+	if params, ok := req.(map[string]interface{}); ok {
+		payload = genericValue{schema: m.Request, v: params}
+	}
+	if err := e.Encode(&Request{Meta: OutgoingMeta(ctx), Method: message, Payload: payload}); err != nil {
+		return err
+	}
+
+	cl := &call{msg: m, done: make(chan callResult, 1)}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.seq++
+	f.Xid = c.seq
+	if !m.OneWay {
+		c.pending[f.Xid] = cl
+	}
+	c.mu.Unlock()
+
+	if err := c.write(ctx, f); err != nil {
+		return err
+	}
+	if m.OneWay {
+		return nil
+	}
+
+	select {
+	case res := <-cl.done:
+		if md, ok := ctx.Value(responseMetaKey{}).(*map[string]string); ok && md != nil {
+			*md = res.meta
+		}
+		if res.err != nil || resp == nil {
+			return res.err
+		}
+		if v, ok := resp.(*interface{}); ok {
+			g := genericValue{schema: m.Response}
+			err := res.d.Decode(&g)
+			*v = g.v
+			return err
+		}
+		return res.d.Decode(resp)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, f.Xid)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// write writes f, within the deadline of ctx if conn supports write
+// deadlines. A failed write breaks the client, as part of the frame may
+// have been written.
+func (c *Client) write(ctx context.Context, f *Frame) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if dc, ok := c.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		if deadline, ok := ctx.Deadline(); ok {
+			dc.SetWriteDeadline(deadline)
+			defer dc.SetWriteDeadline(time.Time{})
+		}
+	}
+	if err := f.Encode(c.conn); err != nil {
+		err = &ConnError{err}
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+// handShake does the handshake with a call without message, then reads
+// the responses of calls.
+func (c *Client) handShake() {
+	defer close(c.handShakeDone)
+	sendProtocol := false
+	for {
+		req := NewHandShakeRequest(c.protocol.text)
+		if sendProtocol {
+			text := c.protocol.String()
+			req.ClientProtocol = avro.MakeUnion(1, new(avro.Null), &text)
+		}
+		req.Meta = metaUnion(c.handShakeMeta)
+		f := new(Frame)
+		e := avro.NewEncoder(f)
+		e.Encode(req)
+		// no message
+		writeMeta(e, nil)
+		e.WriteString("")
+		e.Flush()
+		if err := c.write(context.Background(), f); err != nil {
+			return
+		}
+
+		f.Reset()
+		if err := f.DecodeLimit(c.conn, c.maxFrameSize); err != nil {
+			c.fail(&ConnError{err})
+			return
+		}
+		rep := NewHandShakeResponse(NONE, c.protocol.text)
+		if err := avro.NewBytesDecoder(f.Bytes()).Decode(rep); err != nil {
+			c.fail(&ConnError{err})
+			return
+		}
+		if rep.Match != NONE {
+			c.serverMeta = unionMeta(rep.Meta)
+			c.handShakeOK = true
+			break
+		}
+		if sendProtocol {
+			c.fail(HandShakeError(rep.Match))
+			return
+		}
+		sendProtocol = true
+	}
+	go c.readLoop()
+}
+
+func (c *Client) readLoop() {
+	for {
+		f := new(Frame)
+		if err := f.DecodeLimit(c.conn, c.maxFrameSize); err != nil {
+			c.fail(&ConnError{err})
+			return
+		}
+		c.mu.Lock()
+		cl := c.pending[f.Xid]
+		delete(c.pending, f.Xid)
+		c.mu.Unlock()
+		if cl == nil {
+			// the call was canceled
+			continue
+		}
+
+		d := avro.NewBytesDecoder(f.Bytes())
+		var rep Response
+		if err := d.Decode(&rep); err != nil {
+			cl.done <- callResult{err: err}
+			continue
+		}
+		if !rep.Error {
+			cl.done <- callResult{meta: rep.Meta, d: d}
+			continue
+		}
+		remote, err := readError(d, cl.msg)
+		if err == nil {
+			err = remote
+		}
+		cl.done <- callResult{meta: rep.Meta, err: err}
+	}
+}
+
+// fail breaks the client with err, failing the pending calls.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	pending := c.pending
+	c.pending = make(map[int32]*call)
+	c.mu.Unlock()
+	for _, cl := range pending {
+		cl.done <- callResult{err: err}
+	}
+	c.conn.Close()
+}
+
+// handShaken reports whether c has completed its handshake.
+func (c *Client) handShaken() bool {
+	select {
+	case <-c.handShakeDone:
+		return c.handShakeOK
+	default:
+		return false
+	}
+}
+
+// broken returns the error breaking c, nil if it works.
+func (c *Client) broken() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection. Pending calls fail with ErrClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return nil
+}
@@ -0,0 +1,117 @@
+package ipc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, s *Server) *Client {
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	return NewClient(cc, s.protocol)
+}
+
+func TestClientCall(t *testing.T) {
+	notified := make(chan string, 10)
+	c := newTestClient(t, newMailServer(t, notified))
+	defer c.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(to string) {
+			defer wg.Done()
+			var resp string
+			if err := c.Call(ctx, "send", &sendRequest{To: to}, &resp); err != nil || resp != "sent to "+to {
+				t.Error(resp, err)
+			}
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+
+	err := c.Call(ctx, "send", &sendRequest{To: "nobody"}, nil)
+	if e, ok := err.(*notFound); !ok || e.ID != 7 {
+		t.Error(err)
+	}
+	if err := c.Call(ctx, "send", &sendRequest{To: "crash"}, nil); err != RemoteError("server crashed") {
+		t.Error(err)
+	}
+	if err := c.Call(ctx, "notify", &notifyRequest{"x"}, nil); err != nil {
+		t.Error(err)
+	}
+	if to := <-notified; to != "x" {
+		t.Error(to)
+	}
+	if err := c.Call(ctx, "unknown", nil, nil); err == nil {
+		t.Error("unknown message")
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	p := newMailServer(t, nil).protocol
+	s := NewServer(p)
+	release := make(chan struct{})
+	s.Handle("send", func(ctx context.Context, req *sendRequest) (string, error) {
+		if req.To == "slow" {
+			<-release
+		}
+		return req.To, nil
+	})
+	c := newTestClient(t, s)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var resp string
+	if err := c.Call(ctx, "send", &sendRequest{To: "slow"}, &resp); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	close(release)
+	// the late response is discarded
+	if err := c.Call(context.Background(), "send", &sendRequest{To: "fast"}, &resp); err != nil || resp != "fast" {
+		t.Fatal(resp, err)
+	}
+	if resp == "slow" {
+		t.Error("response of the canceled call")
+	}
+}
+
+func TestClientConnDrop(t *testing.T) {
+	p := newMailServer(t, nil).protocol
+	s := NewServer(p)
+	calling := make(chan struct{})
+	s.Handle("send", func(ctx context.Context, req *sendRequest) (string, error) {
+		close(calling)
+		<-ctx.Done()
+		return "", nil
+	})
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := NewClient(cc, p)
+
+	done := make(chan error)
+	go func() {
+		done <- c.Call(context.Background(), "send", &sendRequest{}, nil)
+	}()
+	<-calling
+	sc.Close()
+	select {
+	case err := <-done:
+		if _, ok := err.(*ConnError); !ok {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call not failed")
+	}
+	if err := c.Call(context.Background(), "send", &sendRequest{}, nil); err == nil {
+		t.Error("call on a broken client")
+	}
+	c.Close()
+	if err := c.Call(context.Background(), "send", &sendRequest{}, nil); err == nil {
+		t.Error("call on a closed client")
+	}
+}
@@ -1,130 +1,307 @@
 package ipc
 
 import (
-        "avro"
-        "io"
-        "net"
-        "net/rpc"
-        "sync"
+	"avro"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
 )
 
 type clientCodec struct {
-        rwc       io.ReadWriteCloser
-        dec       *avro.Decoder
-        enc       *avro.Encoder
-        fout      *Frame
-        fin       *Frame
-        proto     []byte
-        handShake bool
-        mutex     sync.Mutex
+	rwc      io.ReadWriteCloser
+	dec      *avro.Decoder
+	enc      *avro.Encoder
+	fout     *Frame
+	proto    []byte
+	protocol *Protocol
+
+	mutex     sync.Mutex
+	handShake bool
+	// handShaking is closed when the response to the handshake in
+	// flight is read. The server reads one handshake per connection, so
+	// requests wait for it.
+	handShaking  chan struct{}
+	sendProtocol bool              // the server does not know our protocol
+	pending      map[uint64]string // message of each call by seq
+
+	frames  chan *Frame   // frames read by readLoop
+	oneWay  chan uint64   // one-way calls written, which have no response
+	done    chan struct{} // closed when readLoop ends with readErr
+	closed  chan struct{}
+	readErr error
+	noBody  bool  // the current response has no body to read
+	remote  error // the error of the current response with errorReply
+
+	errorReply bool
 }
 
 type HandShakeError int
 
 func (e HandShakeError) Error() string {
-        return "handshake error"
+	return "handshake error"
 }
 
+// NewClientCodec returns a net/rpc codec for the protocol with JSON
+// proto. The service method of a call is the name of the message. If
+// proto can not be parsed, only system errors of calls are decoded.
+//
+// A call of a one-way message completes once it is written, except for
+// the first call, whose response completes the handshake.
 func NewClientCodec(rwc io.ReadWriteCloser, proto []byte) *clientCodec {
-        var fin, fout Frame
-        dec := avro.NewDecoder(&fin)
-        enc := avro.NewEncoder(&fout)
-        return &clientCodec{
-                rwc:   rwc,
-                dec:   dec,
-                enc:   enc,
-                fout:  &fout,
-                fin:   &fin,
-                proto: proto,
-        }
+	var fout Frame
+	protocol, _ := ParseProtocol(proto)
+	c := &clientCodec{
+		rwc:      rwc,
+		dec:      avro.NewBytesDecoder(nil),
+		enc:      avro.NewEncoder(&fout),
+		fout:     &fout,
+		proto:    proto,
+		protocol: protocol,
+		pending:  make(map[uint64]string),
+		frames:   make(chan *Frame),
+		oneWay:   make(chan uint64),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// SetErrorReply makes c decode the error of a failed call into its
+// reply, which must then be an *avro.Union of the response and an *error,
+// instead of rpc.Client.Call returning an rpc.ServerError with the text
+// of the error. Idx is 1 for a failed call, and the error is a
+// RemoteError or the typed error of a declared error:
+//
+//	var resp string
+//	var err error
+//	reply := avro.MakeUnion(0, &resp, &err)
+//	if err := client.Call("send", req, &reply); err != nil {
+//	        ... // the call was not made
+//	}
+//	if reply.Idx == 1 {
+//	        ... // err is the error of the call
+//	}
+func (c *clientCodec) SetErrorReply(on bool) {
+	c.errorReply = on
+}
+
+func (c *clientCodec) readLoop() {
+	for {
+		f := new(Frame)
+		if err := f.Decode(c.rwc); err != nil {
+			c.readErr = err
+			close(c.done)
+			return
+		}
+		select {
+		case c.frames <- f:
+		case <-c.closed:
+			c.readErr = rpc.ErrShutdown
+			close(c.done)
+			return
+		}
+	}
 }
 
 func (c *clientCodec) writeHandShake() error {
-        req := NewHandShakeRequest(c.proto)
-        return c.enc.Encode(req)
+	req := NewHandShakeRequest(c.proto)
+	if c.sendProtocol {
+		proto := string(c.proto)
+		req.ClientProtocol = avro.MakeUnion(1, new(avro.Null), &proto)
+	}
+	return c.enc.Encode(req)
 }
 
 func (c *clientCodec) readHandShake() error {
-        rep := NewHandShakeResponse(NONE, c.proto)
-        err := c.dec.Decode(rep)
-        if err != nil {
-                return err
-        }
-        if rep.Match == NONE {
-                return HandShakeError(rep.Match)
-        }
-        return nil
+	rep := NewHandShakeResponse(NONE, c.proto)
+	err := c.dec.Decode(rep)
+	if err != nil {
+		return err
+	}
+	if rep.Match == NONE {
+		return HandShakeError(rep.Match)
+	}
+	return nil
 }
 
 func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
-        c.mutex.Lock()
-        defer c.mutex.Unlock()
-        if !c.handShake {
-                err := c.writeHandShake()
-                if err != nil {
-                        return err
-                }
-        }
-        req := Request{
-                Method:  r.ServiceMethod,
-                Payload: param,
-        }
-        err := c.enc.Encode(&req)
-        if err != nil {
-                return err
-        }
-        c.fout.Xid = int32(r.Seq)
-        return c.fout.Encode(c.rwc)
+	c.mutex.Lock()
+	for c.handShaking != nil {
+		ch := c.handShaking
+		c.mutex.Unlock()
+		select {
+		case <-ch:
+		case <-c.done:
+			return c.readErr
+		}
+		c.mutex.Lock()
+	}
+	err := c.writeRequest(r, param)
+	oneWay := err == nil && c.handShake && c.isOneWay(r.ServiceMethod)
+	if err == nil && !oneWay {
+		c.pending[r.Seq] = r.ServiceMethod
+	}
+	if err == nil {
+		err = c.fout.Encode(c.rwc)
+	}
+	c.mutex.Unlock()
+	if err != nil || !oneWay {
+		return err
+	}
+	select {
+	case c.oneWay <- r.Seq:
+		return nil
+	case <-c.done:
+		return c.readErr
+	}
 }
 
+// writeRequest writes the handshake, if needed, and the request to
+// c.fout.
+func (c *clientCodec) writeRequest(r *rpc.Request, param interface{}) error {
+	if !c.handShake {
+		if err := c.writeHandShake(); err != nil {
+			c.fout.Reset()
+			return err
+		}
+	}
+	req := Request{
+		Method:  r.ServiceMethod,
+		Payload: param,
+	}
+	if err := c.enc.Encode(&req); err != nil {
+		c.fout.Reset()
+		return err
+	}
+	if !c.handShake {
+		c.handShaking = make(chan struct{})
+	}
+	c.fout.Xid = int32(r.Seq)
+	return nil
+}
+
+func (c *clientCodec) isOneWay(method string) bool {
+	if c.protocol == nil {
+		return false
+	}
+	m := c.protocol.Messages[method]
+	return m != nil && m.OneWay
+}
+
+func (c *clientCodec) message(seq uint64) *Message {
+	c.mutex.Lock()
+	name := c.pending[seq]
+	delete(c.pending, seq)
+	c.mutex.Unlock()
+	if c.protocol == nil {
+		return nil
+	}
+	return c.protocol.Messages[name]
+}
+
+// ReadResponseHeader reads a response up to its body. An error of the
+// call is decoded here and reported in r.Error, so rpc.Client.Call
+// returns an rpc.ServerError holding its text: the message of a
+// RemoteError, or the Error() of a declared error. With SetErrorReply
+// it is kept for ReadResponseBody instead.
 func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
-        err := c.fin.Decode(c.rwc)
-        if err != nil {
-                return err
-        }
-        if !c.handShake {
-                err := c.readHandShake()
-                _, ok := err.(HandShakeError)
-                if err != nil && !ok {
-                        return err
-                }
-                c.handShake = true
-        }
-        r.Seq = uint64(c.fin.Xid)
-        return nil
+	var f *Frame
+	select {
+	case seq := <-c.oneWay:
+		r.Seq = seq
+		c.noBody = true
+		return nil
+	case f = <-c.frames:
+	case <-c.done:
+		return c.readErr
+	}
+	c.dec.ResetBytes(f.Bytes())
+	r.Seq = uint64(f.Xid)
+	c.noBody = false
+	c.remote = nil
+
+	c.mutex.Lock()
+	handShaking := c.handShaking
+	c.mutex.Unlock()
+	if handShaking != nil {
+		err := c.readHandShake()
+		_, ok := err.(HandShakeError)
+		if err != nil && !ok {
+			return err
+		}
+		c.mutex.Lock()
+		c.handShake = err == nil
+		c.sendProtocol = ok
+		c.handShaking = nil
+		c.mutex.Unlock()
+		close(handShaking)
+		if ok {
+			// the server did not take the call
+			c.message(r.Seq)
+			r.Error = err.Error()
+			return nil
+		}
+	}
+	m := c.message(r.Seq)
+	c.noBody = m != nil && m.OneWay
+
+	var rep Response
+	if err := c.dec.Decode(&rep); err != nil {
+		return err
+	}
+	if rep.Error {
+		remote, err := readError(c.dec, m)
+		if err != nil {
+			return err
+		}
+		if c.errorReply {
+			c.remote = remote
+			return nil
+		}
+		r.Error = remote.Error()
+	}
+	return nil
 }
 
+// ReadResponseBody decodes the response into x, or into Elem[0] if x is
+// an *avro.Union. With SetErrorReply, the error of a failed call is
+// stored in the *error of Elem[1].
 func (c *clientCodec) ReadResponseBody(x interface{}) error {
-        u, ok := x.(*avro.Union)
-        if !ok {
-                panic("response must be *avro.Union")
-        }
-        rep := Response{
-                Meta:  make(map[string]string),
-                Error: false,
-        }
-        err := c.dec.Decode(&rep)
-        if err != nil {
-                return err
-        }
-        if !rep.Error {
-                u.Idx = 0
-                err = c.dec.Decode(u.Elem[0])
-        } else {
-                u.Idx = 1
-                err = c.dec.Decode(u.Elem[1])
-        }
-        return err
+	if x == nil || c.noBody {
+		return nil
+	}
+	if c.remote != nil {
+		u, ok := x.(*avro.Union)
+		var perr *error
+		if ok && len(u.Elem) > 1 {
+			perr, ok = u.Elem[1].(*error)
+		}
+		if !ok {
+			return fmt.Errorf("reply of a failed call is not a union of the response and an *error:%T", x)
+		}
+		u.Idx = 1
+		*perr = c.remote
+		return nil
+	}
+	if u, ok := x.(*avro.Union); ok {
+		u.Idx = 0
+		x = u.Elem[0]
+	}
+	return c.dec.Decode(x)
 }
 
 func (c *clientCodec) Close() error {
-        return c.rwc.Close()
+	close(c.closed)
+	return c.rwc.Close()
 }
 
 func Dial(addr string, proto []byte) (*rpc.Client, error) {
-        conn, err := net.Dial("tcp", addr)
-        if err != nil {
-                return nil, err
-        }
-        return rpc.NewClientWithCodec(NewClientCodec(conn, proto)), nil
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClientWithCodec(NewClientCodec(conn, proto)), nil
 }
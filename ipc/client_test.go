@@ -0,0 +1,214 @@
+package ipc
+
+import (
+	"avro"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+const mailProto = `{"protocol": "Mail", "namespace": "example",
+        "types": [
+                {"type": "error", "name": "NotFound", "fields": [
+                        {"name": "message", "type": "string"},
+                        {"name": "id", "type": "long"}
+                ]},
+                {"type": "error", "name": "Busy", "fields": [{"name": "retry", "type": "int"}]}
+        ],
+        "messages": {
+                "send": {
+                        "request": [{"name": "to", "type": "string"}, {"name": "body", "type": "string"}],
+                        "response": "string",
+                        "errors": ["NotFound", "Busy"]
+                },
+                "notify": {
+                        "request": [{"name": "to", "type": "string"}],
+                        "response": "null",
+                        "one-way": true
+                },
+                "ping": {"request": [], "response": "null"}
+        }
+}`
+
+type sendRequest struct {
+	To   string `avro:"to"`
+	Body string `avro:"body"`
+}
+
+type notFound struct {
+	Message string `avro:"message"`
+	ID      int64  `avro:"id"`
+}
+
+func (e *notFound) Error() string {
+	return e.Message
+}
+
+func init() {
+	RegisterError("example.NotFound", (*notFound)(nil))
+}
+
+// fakeServer answers each call to send on conn with respond, which
+// writes the response body after the error flag.
+func fakeServer(t *testing.T, conn net.Conn, respond func(e *avro.Encoder, req sendRequest)) {
+	defer conn.Close()
+	var fin, fout Frame
+	handShake := false
+	for {
+		fin.Reset()
+		if err := fin.Decode(conn); err != nil {
+			return
+		}
+		d := avro.NewBytesDecoder(fin.Bytes())
+		e := avro.NewEncoder(&fout)
+		if !handShake {
+			if err := d.Decode(NewHandShakeRequest(nil)); err != nil {
+				t.Error(err)
+				return
+			}
+			e.Encode(NewHandShakeResponse(BOTH, nil))
+			handShake = true
+		}
+		var meta map[string]string
+		if err := readMeta(d, &meta); err != nil {
+			t.Error(err)
+			return
+		}
+		method, _ := d.ReadString()
+		if method != "send" {
+			t.Error("unexpected message", method)
+			return
+		}
+		var req sendRequest
+		if err := d.Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		writeMeta(e, nil)
+		respond(e, req)
+		e.Flush()
+		fout.Xid = fin.Xid
+		if err := fout.Encode(conn); err != nil {
+			return
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	cc, sc := net.Pipe()
+	go fakeServer(t, sc, func(e *avro.Encoder, req sendRequest) {
+		switch req.To {
+		case "nobody":
+			e.WriteBool(true)
+			e.WriteUnionIndex(1)
+			e.WriteValue(notFound{"no such user", 7})
+		case "busy":
+			e.WriteBool(true)
+			e.WriteUnionIndex(2)
+			e.WriteInt(3)
+		case "crash":
+			e.WriteBool(true)
+			e.WriteUnionIndex(0)
+			e.WriteString("server crashed")
+		default:
+			e.WriteBool(false)
+			e.WriteString("sent to " + req.To)
+		}
+	})
+	c := rpc.NewClientWithCodec(NewClientCodec(cc, []byte(mailProto)))
+	defer c.Close()
+
+	errs := map[string]string{
+		"nobody": "no such user",
+		"busy":   `example.Busy:{"retry":3}`,
+		"crash":  "server crashed",
+	}
+	for to, msg := range errs {
+		var resp string
+		err := c.Call("send", sendRequest{To: to}, &resp)
+		if err != rpc.ServerError(msg) {
+			t.Errorf("%s: %v", to, err)
+		}
+	}
+	// the connection survives errors
+	var resp string
+	if err := c.Call("send", sendRequest{To: "bob"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp != "sent to bob" {
+		t.Error(resp)
+	}
+}
+
+func TestClientErrorReply(t *testing.T) {
+	cc, sc := net.Pipe()
+	go fakeServer(t, sc, func(e *avro.Encoder, req sendRequest) {
+		switch req.To {
+		case "nobody":
+			e.WriteBool(true)
+			e.WriteUnionIndex(1)
+			e.WriteValue(notFound{"no such user", 7})
+		case "crash":
+			e.WriteBool(true)
+			e.WriteUnionIndex(0)
+			e.WriteString("server crashed")
+		default:
+			e.WriteBool(false)
+			e.WriteString("sent to " + req.To)
+		}
+	})
+	codec := NewClientCodec(cc, []byte(mailProto))
+	codec.SetErrorReply(true)
+	c := rpc.NewClientWithCodec(codec)
+	defer c.Close()
+
+	call := func(to string) (string, error) {
+		var resp string
+		var callErr error
+		reply := avro.MakeUnion(0, &resp, &callErr)
+		if err := c.Call("send", sendRequest{To: to}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if (reply.Idx == 1) != (callErr != nil) {
+			t.Error(reply.Idx, callErr)
+		}
+		return resp, callErr
+	}
+	if _, err := call("nobody"); err.(*notFound).ID != 7 {
+		t.Error(err)
+	}
+	if _, err := call("crash"); err != RemoteError("server crashed") {
+		t.Error(err)
+	}
+	if resp, err := call("bob"); err != nil || resp != "sent to bob" {
+		t.Error(resp, err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	p, err := ParseProtocol([]byte(mailProto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := p.Messages["send"]
+
+	b, _ := avro.Marshal(avro.MakeUnion(1, new(string), &notFound{"gone", 1}))
+	remote, err := readError(avro.NewBytesDecoder(b), m)
+	if e, ok := remote.(*notFound); err != nil || !ok || e.ID != 1 {
+		t.Error(remote, err)
+	}
+
+	b = append([]byte{4}, avro.AppendInt(nil, 5)...)
+	remote, err = readError(avro.NewBytesDecoder(b), m)
+	if e, ok := remote.(*ErrorRecord); err != nil || !ok || e.Value["retry"] != int32(5) {
+		t.Error(remote, err)
+	}
+
+	b, _ = avro.Marshal(avro.MakeUnion(0, new(string)))
+	if remote, err = readError(avro.NewBytesDecoder(b), nil); remote != RemoteError("") || err != nil {
+		t.Error(remote, err)
+	}
+	if _, err = readError(avro.NewBytesDecoder([]byte{2}), nil); err == nil {
+		t.Error("declared error of an unknown message")
+	}
+}
@@ -0,0 +1,150 @@
+package ipc
+
+import (
+	"avro"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// RemoteError is a system error of the server, the string branch of the
+// errors of a message.
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
+
+// ErrorRecord is a declared error of a message whose name has no Go type
+// registered with RegisterError.
+type ErrorRecord struct {
+	Schema *avro.Schema
+	// Value is the record in the generic representation.
+	Value map[string]interface{}
+}
+
+func (e *ErrorRecord) Error() string {
+	if msg, ok := e.Value["message"].(string); ok {
+		return e.Schema.Name + ":" + msg
+	}
+	b, err := avro.ToJSON(e.Schema, e.Value)
+	if err != nil {
+		return e.Schema.Name
+	}
+	return e.Schema.Name + ":" + string(b)
+}
+
+var (
+	errorMu    sync.RWMutex
+	errorTypes = make(map[string]reflect.Type)
+	errorNames = make(map[reflect.Type]string)
+)
+
+// RegisterError declares the Go type of the error record with full name
+// name, e.g.
+//
+//	ipc.RegisterError("com.example.NotFound", (*NotFound)(nil))
+//
+// Errors of that record are then decoded into a new value of the type of
+// err, a struct or a pointer to one, and returned from calls. A Server
+// encodes errors of that type as the declared error.
+func RegisterError(name string, err error) {
+	t := reflect.TypeOf(err)
+	if t == nil {
+		panic(fmt.Errorf("RegisterError need a value of the error type"))
+	}
+	et := t
+	if et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		panic(fmt.Errorf("error type must be a struct or a pointer to struct:%s", t))
+	}
+	errorMu.Lock()
+	defer errorMu.Unlock()
+	if _, ok := errorTypes[name]; ok {
+		panic(fmt.Errorf("duplicate error %s", name))
+	}
+	errorTypes[name] = t
+	errorNames[t] = name
+}
+
+func lookupError(name string) reflect.Type {
+	errorMu.RLock()
+	defer errorMu.RUnlock()
+	return errorTypes[name]
+}
+
+// readError reads the errors union of message m into remote. m may be
+// nil if the message is unknown, and then only system errors can be
+// read.
+func readError(d *avro.Decoder, m *Message) (remote error, err error) {
+	idx, err := d.ReadUnionIndex()
+	if err != nil {
+		return nil, err
+	}
+	if idx == 0 {
+		msg, err := d.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		return RemoteError(msg), nil
+	}
+	if m == nil || idx < 0 || idx >= len(m.Errors.Branches) {
+		return nil, fmt.Errorf("error index error:%d", idx)
+	}
+	s := m.Errors.Branches[idx]
+	t := lookupError(s.Name)
+	if t == nil {
+		v, err := d.ReadGeneric(s)
+		if err != nil {
+			return nil, err
+		}
+		return &ErrorRecord{Schema: s, Value: v.(map[string]interface{})}, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		if err := d.Decode(v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Interface().(error), nil
+	}
+	v := reflect.New(t)
+	if err := d.Decode(v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface().(error), nil
+}
+
+// writeError writes err in the errors union of message m: a registered
+// error or an *ErrorRecord in the branch of its declared error, and any
+// other error as a system error holding its text.
+func writeError(e *avro.Encoder, m *Message, err error) error {
+	var name string
+	if r, ok := err.(*ErrorRecord); ok {
+		name = r.Schema.Name
+	} else {
+		errorMu.RLock()
+		name = errorNames[reflect.TypeOf(err)]
+		errorMu.RUnlock()
+	}
+	idx := -1
+	if m != nil && name != "" {
+		for i, b := range m.Errors.Branches[1:] {
+			if b.Name == name {
+				idx = i + 1
+				break
+			}
+		}
+	}
+	if idx == -1 {
+		e.WriteUnionIndex(0)
+		e.WriteString(err.Error())
+		return nil
+	}
+	e.WriteUnionIndex(idx)
+	if r, ok := err.(*ErrorRecord); ok {
+		return e.WriteGeneric(m.Errors.Branches[idx], r.Value)
+	}
+	return e.WriteValue(reflect.Indirect(reflect.ValueOf(err)).Interface())
+}
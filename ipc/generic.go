@@ -0,0 +1,50 @@
+package ipc
+
+import (
+	"avro"
+	"context"
+)
+
+// genericValue is a value in the generic representation of avro.ReadGeneric
+// of schema.
+type genericValue struct {
+	schema *avro.Schema
+	v      interface{}
+}
+
+func (g genericValue) MarshalAvro(e *avro.Encoder) error {
+	return e.WriteGeneric(g.schema, g.v)
+}
+
+func (g *genericValue) UnmarshalAvro(d *avro.Decoder) (err error) {
+	g.v, err = d.ReadGeneric(g.schema)
+	return err
+}
+
+// CallGeneric calls message with its parameters in the generic
+// representation of avro.ReadGeneric, by name, and returns the response in
+// that representation, e.g.
+//
+//	resp, err := c.CallGeneric(ctx, "send", map[string]interface{}{"to": "bob", "body": "hi"})
+//
+// It calls any protocol parsed with ParseProtocol without Go types. Errors
+// are as for Call, with declared errors whose type is not registered as
+// *ErrorRecord.
+func (c *Client) CallGeneric(ctx context.Context, message string, params map[string]interface{}) (interface{}, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	var resp interface{}
+	err := c.Call(ctx, message, params, &resp)
+	return resp, err
+}
+
+// CallGeneric is Client.CallGeneric on a connection of the pool.
+func (p *Pool) CallGeneric(ctx context.Context, message string, params map[string]interface{}) (interface{}, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	var resp interface{}
+	err := p.Call(ctx, message, params, &resp)
+	return resp, err
+}
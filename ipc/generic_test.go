@@ -0,0 +1,116 @@
+package ipc
+
+import (
+	"avro"
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCallGeneric(t *testing.T) {
+	notified := make(chan string, 1)
+	s := newMailServer(t, notified)
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := NewClient(cc, s.protocol)
+	defer c.Close()
+	ctx := context.Background()
+
+	resp, err := c.CallGeneric(ctx, "send", map[string]interface{}{"to": "bob", "body": "hi"})
+	if err != nil || resp != "sent to bob" {
+		t.Fatal(resp, err)
+	}
+	if _, err := c.CallGeneric(ctx, "send", map[string]interface{}{"to": "nobody", "body": ""}); err.(*notFound).ID != 7 {
+		t.Error(err)
+	}
+	// missing parameter
+	if _, err := c.CallGeneric(ctx, "send", map[string]interface{}{"to": "bob"}); err == nil {
+		t.Error("missing body")
+	}
+	if resp, err := c.CallGeneric(ctx, "notify", map[string]interface{}{"to": "x"}); err != nil || resp != nil {
+		t.Error(resp, err)
+	}
+	if to := <-notified; to != "x" {
+		t.Error(to)
+	}
+	if _, err := c.CallGeneric(ctx, "ping", nil); err != RemoteError("no handler of message ping") {
+		t.Error(err)
+	}
+}
+
+const numberProto = `{"protocol": "Number", "messages": {
+        "integer": {"request": [{"name": "long", "type": "boolean"}], "response": ["int", "long"]},
+        "real": {"request": [{"name": "double", "type": "boolean"}], "response": ["float", "double"]},
+        "entry": {"request": [{"name": "record", "type": "boolean"}], "response": [
+                {"type": "map", "values": "string"},
+                {"type": "record", "name": "Entry", "fields": [{"name": "a", "type": "string"}]}]},
+        "symbol": {"request": [{"name": "enum", "type": "boolean"}], "response": [
+                "string", {"type": "enum", "name": "Kind", "symbols": ["a"]}]}
+}}`
+
+func TestCallGenericUnion(t *testing.T) {
+	p, err := ParseProtocol([]byte(numberProto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResponder(p)
+	r.Handle("integer", func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+		if params["long"].(bool) {
+			return int64(1), nil
+		}
+		return int32(1), nil
+	})
+	r.Handle("real", func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+		if params["double"].(bool) {
+			return float64(0.5), nil
+		}
+		return float32(0.5), nil
+	})
+	r.Handle("entry", func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+		if params["record"].(bool) {
+			return avro.Branch{Name: "Entry", Value: map[string]interface{}{"a": "x"}}, nil
+		}
+		return map[string]interface{}{"a": "x"}, nil
+	})
+	r.Handle("symbol", func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+		if params["enum"].(bool) {
+			return avro.Branch{Name: "Kind", Value: "a"}, nil
+		}
+		return "a", nil
+	})
+	cc, sc := net.Pipe()
+	go r.ServeConn(sc)
+	c := NewClient(cc, p)
+	defer c.Close()
+
+	tests := []struct {
+		message string
+		param   string
+		branch  bool
+		resp    interface{}
+		json    string
+	}{
+		{"integer", "long", false, int32(1), `{"int":1}`},
+		{"integer", "long", true, int64(1), `{"long":1}`},
+		{"real", "double", false, float32(0.5), `{"float":0.5}`},
+		{"real", "double", true, float64(0.5), `{"double":0.5}`},
+		{"entry", "record", false, map[string]interface{}{"a": "x"}, `{"map":{"a":"x"}}`},
+		{"entry", "record", true, avro.Branch{Name: "Entry", Value: map[string]interface{}{"a": "x"}}, `{"Entry":{"a":"x"}}`},
+		{"symbol", "enum", false, "a", `{"string":"a"}`},
+		{"symbol", "enum", true, avro.Branch{Name: "Kind", Value: "a"}, `{"Kind":"a"}`},
+	}
+	for _, test := range tests {
+		resp, err := c.CallGeneric(context.Background(), test.message, map[string]interface{}{test.param: test.branch})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp, test.resp) {
+			t.Errorf("%s %v: %T %v, expect %T %v", test.message, test.branch, resp, resp, test.resp, test.resp)
+		}
+		b, err := avro.ToJSON(p.Messages[test.message].Response, resp)
+		if err != nil || string(b) != test.json {
+			t.Errorf("%s %v: %s %v, expect %s", test.message, test.branch, b, err, test.json)
+		}
+	}
+}
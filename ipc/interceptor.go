@@ -0,0 +1,180 @@
+package ipc
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Invoker makes the call of message with the request req, decoding the
+// response into resp, as Client.Call.
+type Invoker func(ctx context.Context, message string, req, resp interface{}) error
+
+// ClientInterceptor runs around the calls of a Client. It makes the call
+// with invoke, and may change ctx, e.g. with WithMeta, before, and the
+// error after.
+type ClientInterceptor func(ctx context.Context, message string, req, resp interface{}, invoke Invoker) error
+
+func (i ClientInterceptor) wrap(invoke Invoker) Invoker {
+	return func(ctx context.Context, message string, req, resp interface{}) error {
+		return i(ctx, message, req, resp, invoke)
+	}
+}
+
+// HandlerFunc serves a call of message with the request req, the value
+// passed to the handler registered with Server.Handle, and returns the
+// response.
+type HandlerFunc func(ctx context.Context, message string, req interface{}) (interface{}, error)
+
+// ServerInterceptor runs around the handlers of a Server, calling next to
+// serve the call. The metadata of the call is in ctx as for handlers.
+type ServerInterceptor func(ctx context.Context, message string, req interface{}, next HandlerFunc) (interface{}, error)
+
+func (i ServerInterceptor) wrap(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, message string, req interface{}) (interface{}, error) {
+		return i(ctx, message, req, next)
+	}
+}
+
+// ClientLogger returns an interceptor logging each call of a Client to
+// l: the message, its duration and error.
+func ClientLogger(l *log.Logger) ClientInterceptor {
+	return func(ctx context.Context, message string, req, resp interface{}, invoke Invoker) error {
+		start := time.Now()
+		err := invoke(ctx, message, req, resp)
+		logCall(l, "call", message, time.Since(start), err)
+		return err
+	}
+}
+
+// ServerLogger returns an interceptor logging each call served by a
+// Server to l: the message, its duration and error.
+func ServerLogger(l *log.Logger) ServerInterceptor {
+	return func(ctx context.Context, message string, req interface{}, next HandlerFunc) (interface{}, error) {
+		start := time.Now()
+		resp, err := next(ctx, message, req)
+		logCall(l, "serve", message, time.Since(start), err)
+		return resp, err
+	}
+}
+
+func logCall(l *log.Logger, op, message string, d time.Duration, err error) {
+	if err != nil {
+		l.Printf("ipc %s %s %s error:%s", op, message, d, err)
+		return
+	}
+	l.Printf("ipc %s %s %s", op, message, d)
+}
+
+// DefaultLatencyBounds are the bucket bounds of a LatencyHistogram made
+// without bounds.
+var DefaultLatencyBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// LatencyHistogram counts the durations of calls per message in buckets.
+// Its interceptors may be used by several clients and servers.
+type LatencyHistogram struct {
+	bounds []time.Duration
+
+	mu    sync.Mutex
+	stats map[string]*LatencyStats
+}
+
+// LatencyStats are the durations of the calls of a message.
+type LatencyStats struct {
+	// Counts[i] is the number of calls that took at most Bounds[i], and
+	// the last count that of the calls that took longer.
+	Bounds []time.Duration
+	Counts []int64
+	Count  int64
+	Errors int64
+	Sum    time.Duration
+}
+
+// NewLatencyHistogram returns a LatencyHistogram with buckets bounded by
+// the increasing durations bounds, or DefaultLatencyBounds if none.
+func NewLatencyHistogram(bounds ...time.Duration) *LatencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBounds
+	}
+	return &LatencyHistogram{
+		bounds: append([]time.Duration(nil), bounds...),
+		stats:  make(map[string]*LatencyStats),
+	}
+}
+
+// Observe counts a call of message that took d and failed if err is not
+// nil.
+func (h *LatencyHistogram) Observe(message string, d time.Duration, err error) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stats[message]
+	if s == nil {
+		s = &LatencyStats{Bounds: h.bounds, Counts: make([]int64, len(h.bounds)+1)}
+		h.stats[message] = s
+	}
+	s.Counts[i]++
+	s.Count++
+	s.Sum += d
+	if err != nil {
+		s.Errors++
+	}
+}
+
+// Messages returns the sorted names of the messages observed.
+func (h *LatencyHistogram) Messages() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := make([]string, 0, len(h.stats))
+	for name := range h.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats returns a copy of the stats of message.
+func (h *LatencyHistogram) Stats(message string) LatencyStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stats[message]
+	if s == nil {
+		return LatencyStats{Bounds: h.bounds, Counts: make([]int64, len(h.bounds)+1)}
+	}
+	c := *s
+	c.Counts = append([]int64(nil), s.Counts...)
+	return c
+}
+
+// ClientInterceptor returns an interceptor observing the calls of a
+// Client.
+func (h *LatencyHistogram) ClientInterceptor() ClientInterceptor {
+	return func(ctx context.Context, message string, req, resp interface{}, invoke Invoker) error {
+		start := time.Now()
+		err := invoke(ctx, message, req, resp)
+		h.Observe(message, time.Since(start), err)
+		return err
+	}
+}
+
+// ServerInterceptor returns an interceptor observing the calls served by
+// a Server.
+func (h *LatencyHistogram) ServerInterceptor() ServerInterceptor {
+	return func(ctx context.Context, message string, req interface{}, next HandlerFunc) (interface{}, error) {
+		start := time.Now()
+		resp, err := next(ctx, message, req)
+		h.Observe(message, time.Since(start), err)
+		return resp, err
+	}
+}
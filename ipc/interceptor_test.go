@@ -0,0 +1,92 @@
+package ipc
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterceptors(t *testing.T) {
+	s := newMailServer(t, make(chan string, 10))
+	var order []string
+	s.Use(func(ctx context.Context, message string, req interface{}, next HandlerFunc) (interface{}, error) {
+		order = append(order, "server")
+		if MetaFromContext(ctx)["token"] != "secret" {
+			return nil, RemoteError("unauthorized")
+		}
+		return next(ctx, message, req)
+	})
+	var slog bytes.Buffer
+	sh := NewLatencyHistogram(time.Second)
+	s.Use(ServerLogger(log.New(&slog, "", 0)), sh.ServerInterceptor())
+
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := NewClient(cc, s.protocol)
+	defer c.Close()
+	var clog bytes.Buffer
+	ch := NewLatencyHistogram()
+	c.Use(func(ctx context.Context, message string, req, resp interface{}, invoke Invoker) error {
+		order = append(order, "client")
+		return invoke(WithMeta(ctx, map[string]string{"token": "secret"}), message, req, resp)
+	}, ClientLogger(log.New(&clog, "", 0)))
+	c.Use(ch.ClientInterceptor())
+
+	var resp string
+	if err := c.Call(context.Background(), "send", &sendRequest{To: "bob"}, &resp); err != nil || resp != "sent to bob" {
+		t.Fatal(resp, err)
+	}
+	if err := c.Call(context.Background(), "send", &sendRequest{To: "nobody"}, &resp); err == nil {
+		t.Fatal("no error")
+	}
+	if strings.Join(order, " ") != "client server client server" {
+		t.Error(order)
+	}
+	if !strings.Contains(clog.String(), "ipc call send") || !strings.Contains(slog.String(), "error:no such user") {
+		t.Error(clog.String(), slog.String())
+	}
+	for _, h := range []*LatencyHistogram{ch, sh} {
+		st := h.Stats("send")
+		var n int64
+		for _, c := range st.Counts {
+			n += c
+		}
+		if st.Count != 2 || st.Errors != 1 || n != 2 || len(st.Counts) != len(st.Bounds)+1 {
+			t.Error(st)
+		}
+		if m := h.Messages(); len(m) != 1 || m[0] != "send" {
+			t.Error(m)
+		}
+	}
+
+	// without the token
+	c2cc, c2sc := net.Pipe()
+	go s.ServeConn(c2sc)
+	c2 := NewClient(c2cc, s.protocol)
+	defer c2.Close()
+	if err := c2.Call(context.Background(), "send", &sendRequest{To: "bob"}, &resp); err != RemoteError("unauthorized") {
+		t.Error(err)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := NewLatencyHistogram(time.Millisecond, time.Second)
+	h.Observe("m", time.Millisecond, nil)
+	h.Observe("m", 2*time.Millisecond, nil)
+	h.Observe("m", time.Minute, nil)
+	st := h.Stats("m")
+	if st.Counts[0] != 1 || st.Counts[1] != 1 || st.Counts[2] != 1 || st.Sum != time.Minute+3*time.Millisecond {
+		t.Error(st)
+	}
+	st.Counts[0] = 10
+	if h.Stats("m").Counts[0] != 1 {
+		t.Error("stats not copied")
+	}
+	if h.Stats("other").Count != 0 {
+		t.Error("stats of other")
+	}
+}
@@ -0,0 +1,178 @@
+package ipc
+
+import (
+	"avro"
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Protocol is a parsed avro protocol.
+type Protocol struct {
+	// Name is the full name of the protocol.
+	Name     string
+	Doc      string
+	Types    []*avro.Schema
+	Messages map[string]*Message
+	// MD5 is the hash of the JSON of the protocol exchanged in handshakes.
+	MD5 [16]byte
+
+	text []byte
+}
+
+// Message is a message of a protocol.
+type Message struct {
+	Name string
+	Doc  string
+	// Request is a record of the parameters of the message, named after
+	// the message.
+	Request  *avro.Schema
+	Response *avro.Schema
+	// Errors is the union of "string", for system errors, and the
+	// declared errors of the message.
+	Errors *avro.Schema
+	OneWay bool
+}
+
+// ParseProtocol parses a protocol from its JSON form.
+func ParseProtocol(b []byte) (*Protocol, error) {
+	var v struct {
+		Protocol  string
+		Namespace string
+		Doc       string
+		Types     []json.RawMessage
+		Messages  map[string]struct {
+			Doc      string
+			Request  []json.RawMessage
+			Response json.RawMessage
+			Errors   []json.RawMessage
+			OneWay   bool `json:"one-way"`
+		}
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v.Protocol == "" {
+		return nil, fmt.Errorf("protocol without name")
+	}
+	ns := v.Namespace
+	if i := strings.LastIndexByte(v.Protocol, '.'); i >= 0 {
+		ns = v.Protocol[:i]
+	}
+	p := &Protocol{
+		Name:     v.Protocol,
+		Doc:      v.Doc,
+		Messages: make(map[string]*Message, len(v.Messages)),
+		MD5:      md5.Sum(b),
+		text:     b,
+	}
+	if ns != "" && !strings.Contains(p.Name, ".") {
+		p.Name = ns + "." + p.Name
+	}
+
+	names := make(avro.Names)
+	for _, t := range v.Types {
+		s, err := names.Parse(t, ns)
+		if err != nil {
+			return nil, fmt.Errorf("protocol %s:%s", p.Name, err)
+		}
+		p.Types = append(p.Types, s)
+	}
+	for name, mv := range v.Messages {
+		m := &Message{
+			Name:    name,
+			Doc:     mv.Doc,
+			Request: &avro.Schema{Type: avro.TypeRecord, Name: name},
+			Errors: &avro.Schema{
+				Type:     avro.TypeUnion,
+				Branches: []*avro.Schema{{Type: avro.TypeString}},
+			},
+			OneWay: mv.OneWay,
+		}
+		for _, param := range mv.Request {
+			f, err := parseParam(names, param, ns)
+			if err != nil {
+				return nil, fmt.Errorf("message %s:%s", name, err)
+			}
+			m.Request.Fields = append(m.Request.Fields, f)
+		}
+		resp := mv.Response
+		if resp == nil {
+			resp = json.RawMessage(`"null"`)
+		}
+		var err error
+		m.Response, err = names.Parse(resp, ns)
+		if err != nil {
+			return nil, fmt.Errorf("message %s response:%s", name, err)
+		}
+		for _, e := range mv.Errors {
+			s, err := names.Parse(e, ns)
+			if err != nil {
+				return nil, fmt.Errorf("message %s errors:%s", name, err)
+			}
+			if s.Type != avro.TypeRecord {
+				return nil, fmt.Errorf("message %s error is not a record:%s", name, s.Type)
+			}
+			m.Errors.Branches = append(m.Errors.Branches, s)
+		}
+		if m.OneWay && (m.Response.Type != avro.TypeNull || len(mv.Errors) > 0) {
+			return nil, fmt.Errorf("one-way message %s with response or errors", name)
+		}
+		p.Messages[name] = m
+	}
+	return p, nil
+}
+
+// parseParam parses a parameter of a message, which is like a field of a
+// record.
+func parseParam(names avro.Names, b []byte, ns string) (*avro.Field, error) {
+	var v struct {
+		Name    string
+		Doc     string
+		Type    json.RawMessage
+		Default json.RawMessage
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v.Name == "" {
+		return nil, fmt.Errorf("parameter without name")
+	}
+	t, err := names.Parse(v.Type, ns)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %s:%s", v.Name, err)
+	}
+	f := &avro.Field{Name: v.Name, Doc: v.Doc, Type: t}
+	if v.Default != nil {
+		dec := json.NewDecoder(bytes.NewReader(v.Default))
+		dec.UseNumber()
+		if err := dec.Decode(&f.Default); err != nil {
+			return nil, err
+		}
+		f.HasDefault = true
+	}
+	return f, nil
+}
+
+// String returns the JSON form of the protocol as it was parsed.
+func (p *Protocol) String() string {
+	return string(p.text)
+}
+
+// Type returns the type of the protocol named name, a full name or a name
+// in the namespace of the protocol, nil if there is none.
+func (p *Protocol) Type(name string) *avro.Schema {
+	if !strings.Contains(name, ".") {
+		if i := strings.LastIndexByte(p.Name, '.'); i >= 0 {
+			name = p.Name[:i+1] + name
+		}
+	}
+	for _, t := range p.Types {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package ipc
+
+import (
+	"avro"
+	"testing"
+)
+
+func TestParseProtocol(t *testing.T) {
+	p, err := ParseProtocol([]byte(mailProto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "example.Mail" || len(p.Types) != 2 {
+		t.Error(p.Name, p.Types)
+	}
+	m := p.Messages["send"]
+	if m == nil || m.OneWay {
+		t.Fatal(m)
+	}
+	if len(m.Request.Fields) != 2 || m.Request.Fields[1].Name != "body" {
+		t.Error(m.Request)
+	}
+	if m.Response.Type != avro.TypeString {
+		t.Error(m.Response)
+	}
+	if len(m.Errors.Branches) != 3 || m.Errors.Branches[1] != p.Types[0] {
+		t.Error(m.Errors)
+	}
+
+	bad := []string{
+		`{"messages": {}}`,
+		`{"protocol": "P", "messages": {"m": {"request": [{"name": "a", "type": "Unknown"}]}}}`,
+		`{"protocol": "P", "messages": {"m": {"request": [], "errors": ["string"]}}}`,
+		`{"protocol": "P", "messages": {"m": {"request": [], "response": "int", "one-way": true}}}`,
+	}
+	for _, b := range bad {
+		if _, err := ParseProtocol([]byte(b)); err == nil {
+			t.Errorf("%s is invalid", b)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ipc
+
+import (
+	"avro"
+	"context"
+	"crypto/tls"
+	"sync"
+)
+
+type (
+	metaKey         struct{}
+	responseMetaKey struct{}
+	callInfoKey     struct{}
+)
+
+// WithMeta returns a copy of ctx carrying meta, which Client.Call sends as
+// the metadata of the request, e.g. a trace id or an auth token. It adds to
+// the metadata already in ctx.
+func WithMeta(ctx context.Context, meta map[string]string) context.Context {
+	old, _ := ctx.Value(metaKey{}).(map[string]string)
+	m := make(map[string]string, len(old)+len(meta))
+	for k, v := range old {
+		m[k] = v
+	}
+	for k, v := range meta {
+		m[k] = v
+	}
+	return context.WithValue(ctx, metaKey{}, m)
+}
+
+// WithResponseMeta returns a copy of ctx in which Client.Call stores the
+// metadata of the response in *meta.
+func WithResponseMeta(ctx context.Context, meta *map[string]string) context.Context {
+	return context.WithValue(ctx, responseMetaKey{}, meta)
+}
+
+// OutgoingMeta returns the metadata of ctx sent by Client.Call. It must not
+// be modified.
+func OutgoingMeta(ctx context.Context) map[string]string {
+	m, _ := ctx.Value(metaKey{}).(map[string]string)
+	return m
+}
+
+// callInfo is the call a Server handler serves.
+type callInfo struct {
+	method        string
+	meta          map[string]string
+	handShakeMeta map[string]string
+	tls           *tls.ConnectionState
+
+	mu       sync.Mutex
+	respMeta map[string]string
+}
+
+func callInfoOf(ctx context.Context) *callInfo {
+	c, _ := ctx.Value(callInfoKey{}).(*callInfo)
+	return c
+}
+
+// MetaFromContext returns the metadata of the request a Server handler
+// serves with ctx. It must not be modified.
+func MetaFromContext(ctx context.Context) map[string]string {
+	if c := callInfoOf(ctx); c != nil {
+		return c.meta
+	}
+	return nil
+}
+
+// HandShakeMetaFromContext returns the metadata of the handshake of the
+// connection a Server handler serves ctx on. It must not be modified.
+func HandShakeMetaFromContext(ctx context.Context) map[string]string {
+	if c := callInfoOf(ctx); c != nil {
+		return c.handShakeMeta
+	}
+	return nil
+}
+
+// SetResponseMeta sets key in the metadata of the response to the call a
+// Server handler serves with ctx. It does nothing outside of a handler.
+func SetResponseMeta(ctx context.Context, key, value string) {
+	c := callInfoOf(ctx)
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.respMeta == nil {
+		c.respMeta = make(map[string]string)
+	}
+	c.respMeta[key] = value
+	c.mu.Unlock()
+}
+
+func (c *callInfo) responseMeta() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.respMeta
+}
+
+// metaUnion returns the ["null", {"type": "map", "values": "bytes"}] union
+// of handshakes holding meta, null if meta is empty.
+func metaUnion(meta map[string]string) avro.Union {
+	if len(meta) == 0 {
+		return avro.MakeUnion(0, new(avro.Null), new(map[string]string))
+	}
+	return avro.MakeUnion(1, new(avro.Null), &meta)
+}
+
+// unionMeta returns the meta of a handshake union read by metaUnion.
+func unionMeta(u avro.Union) map[string]string {
+	if u.Idx != 1 {
+		return nil
+	}
+	m, _ := u.Elem[1].(*map[string]string)
+	if m == nil {
+		return nil
+	}
+	return *m
+}
@@ -0,0 +1,45 @@
+package ipc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	p := newMailServer(t, nil).protocol
+	s := NewServer(p)
+	s.SetHandShakeMeta(map[string]string{"server": "s1"})
+	s.Handle("send", func(ctx context.Context, req *sendRequest) (string, error) {
+		SetResponseMeta(ctx, "span", MetaFromContext(ctx)["trace"]+"/1")
+		return HandShakeMetaFromContext(ctx)["client"], nil
+	})
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := NewClient(cc, p)
+	c.SetHandShakeMeta(map[string]string{"client": "c1"})
+	defer c.Close()
+
+	ctx := WithMeta(context.Background(), map[string]string{"trace": "t1"})
+	ctx = WithMeta(ctx, map[string]string{"token": "secret"})
+	if m := OutgoingMeta(ctx); len(m) != 2 || m["trace"] != "t1" {
+		t.Error(m)
+	}
+	var md map[string]string
+	var resp string
+	if err := c.Call(WithResponseMeta(ctx, &md), "send", &sendRequest{}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp != "c1" {
+		t.Error(resp)
+	}
+	if md["span"] != "t1/1" {
+		t.Error(md)
+	}
+	if m := c.HandShakeMeta(); m["server"] != "s1" {
+		t.Error(m)
+	}
+	if MetaFromContext(ctx) != nil {
+		t.Error("meta outside of a handler")
+	}
+}
@@ -0,0 +1,211 @@
+package ipc
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultPoolSize   = 4
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+	DefaultMaxRetries = 3
+)
+
+// Pool is a Client keeping Size connections, which are dialed again when
+// they fail. Each new connection does its own handshake. A connection
+// failing to dial or breaking waits an exponential backoff, from
+// MinBackoff up to MaxBackoff, before it is dialed again. The backoff
+// starts again from MinBackoff once a connection completes its handshake.
+//
+// Calls failing with a *ConnError are retried, up to MaxRetries times, if
+// the connection failed to dial or the message is idempotent. The fields
+// must be set before the first call.
+type Pool struct {
+	Size       int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	MaxRetries int
+	// Idempotent reports whether message may be called again when it is
+	// unknown if the server got the call. No message is if nil.
+	Idempotent func(message string) bool
+	// HandShakeMeta is the metadata of the handshakes.
+	HandShakeMeta map[string]string
+	// MaxFrameSize is the size of the largest response read.
+	MaxFrameSize int
+
+	dial     func(ctx context.Context) (io.ReadWriteCloser, error)
+	protocol *Protocol
+
+	init   sync.Once
+	slots  []*poolSlot
+	next   uint32
+	closed chan struct{}
+	close  sync.Once
+}
+
+// poolSlot is a connection of a Pool.
+type poolSlot struct {
+	client atomic.Value // *Client, stored with mu held
+
+	mu       sync.Mutex // held while dialing
+	failures int        // consecutive failures
+	retry    time.Time  // time of the next dial
+}
+
+func (s *poolSlot) load() *Client {
+	c, _ := s.client.Load().(*Client)
+	return c
+}
+
+// NewPool returns a Pool calling the messages of p on connections
+// returned by dial.
+func NewPool(dial func(ctx context.Context) (io.ReadWriteCloser, error), p *Protocol) *Pool {
+	return &Pool{
+		Size:         DefaultPoolSize,
+		MinBackoff:   DefaultMinBackoff,
+		MaxBackoff:   DefaultMaxBackoff,
+		MaxRetries:   DefaultMaxRetries,
+		MaxFrameSize: DefaultMaxFrameSize,
+		dial:         dial,
+		protocol:     p,
+		closed:       make(chan struct{}),
+	}
+}
+
+// DialPool returns a Pool of TCP connections to addr.
+func DialPool(addr string, p *Protocol) *Pool {
+	var d net.Dialer
+	return NewPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	}, p)
+}
+
+// Call calls message on a connection of the pool as Client.Call, retrying
+// on failed connections.
+func (p *Pool) Call(ctx context.Context, message string, req, resp interface{}) error {
+	p.setup()
+	idempotent := p.Idempotent != nil && p.Idempotent(message)
+	n := atomic.AddUint32(&p.next, 1)
+	for attempt := 0; ; attempt++ {
+		c, err := p.client(ctx, n)
+		if err == nil {
+			err = c.Call(ctx, message, req, resp)
+			if _, ok := err.(*ConnError); !ok || !idempotent {
+				return err
+			}
+		} else if _, ok := err.(*ConnError); !ok {
+			return err
+		}
+		if attempt >= p.MaxRetries {
+			return err
+		}
+	}
+}
+
+func (p *Pool) setup() {
+	p.init.Do(func() {
+		if p.Size < 1 {
+			p.Size = 1
+		}
+		p.slots = make([]*poolSlot, p.Size)
+		for i := range p.slots {
+			p.slots[i] = new(poolSlot)
+		}
+	})
+}
+
+// client returns a working client of the pool, the first from the slot n,
+// or dials the slot n.
+func (p *Pool) client(ctx context.Context, n uint32) (*Client, error) {
+	size := uint32(len(p.slots))
+	for i := uint32(0); i < size; i++ {
+		c := p.slots[(n+i)%size].load()
+		if c != nil && c.broken() == nil {
+			return c, nil
+		}
+	}
+	s := p.slots[n%size]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		select {
+		case <-p.closed:
+			return nil, ErrClosed
+		default:
+		}
+		if c := s.load(); c != nil {
+			if c.broken() == nil {
+				return c, nil
+			}
+			c.Close()
+			s.client.Store((*Client)(nil))
+			if c.handShaken() {
+				// the connection worked, so the server is back
+				s.failures = 0
+			}
+			s.fail(p)
+		}
+		if wait := time.Until(s.retry); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			case <-p.closed:
+				t.Stop()
+				return nil, ErrClosed
+			}
+		}
+		conn, err := p.dial(ctx)
+		if err != nil {
+			s.fail(p)
+			return nil, &ConnError{err}
+		}
+		c := NewClient(conn, p.protocol)
+		c.SetHandShakeMeta(p.HandShakeMeta)
+		c.SetMaxFrameSize(p.MaxFrameSize)
+		s.client.Store(c)
+	}
+}
+
+// fail counts a failure of the connection of s, delaying its next dial.
+func (s *poolSlot) fail(p *Pool) {
+	backoff := p.MinBackoff
+	for i := 0; i < s.failures && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	s.failures++
+	// half of the backoff is random, not to dial all at once
+	if backoff > 1 {
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+	}
+	s.retry = time.Now().Add(backoff)
+}
+
+// Close closes the connections of the pool. Calls then fail with
+// ErrClosed.
+func (p *Pool) Close() error {
+	p.setup()
+	p.close.Do(func() {
+		close(p.closed)
+		for _, s := range p.slots {
+			s.mu.Lock()
+			if c := s.load(); c != nil {
+				c.Close()
+			}
+			s.mu.Unlock()
+		}
+	})
+	return nil
+}
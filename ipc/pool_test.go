@@ -0,0 +1,170 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pipeDialer dials connections served by a Server over net.Pipe.
+type pipeDialer struct {
+	server *Server
+	fails  int32 // dials to fail
+	dials  int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (d *pipeDialer) dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	atomic.AddInt32(&d.dials, 1)
+	if atomic.AddInt32(&d.fails, -1) >= 0 {
+		return nil, errors.New("connection refused")
+	}
+	cc, sc := net.Pipe()
+	d.mu.Lock()
+	d.conns = append(d.conns, sc)
+	d.mu.Unlock()
+	go d.server.ServeConn(sc)
+	return cc, nil
+}
+
+// drop closes the server side of the connections.
+func (d *pipeDialer) drop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.conns {
+		c.Close()
+	}
+	d.conns = nil
+}
+
+func newTestPool(d *pipeDialer) *Pool {
+	p := NewPool(d.dial, d.server.protocol)
+	p.Size = 2
+	p.MinBackoff = time.Millisecond
+	p.MaxBackoff = 4 * time.Millisecond
+	return p
+}
+
+func TestPool(t *testing.T) {
+	d := &pipeDialer{server: newMailServer(t, nil), fails: 2}
+	p := newTestPool(d)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp string
+			if err := p.Call(ctx, "send", &sendRequest{To: "bob"}, &resp); err != nil || resp != "sent to bob" {
+				t.Error(resp, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&d.dials); n < 3 || n > 4 {
+		t.Error("dials", n)
+	}
+
+	// reconnect after the connections drop
+	d.drop()
+	time.Sleep(10 * time.Millisecond)
+	var resp string
+	if err := p.Call(ctx, "send", &sendRequest{To: "bob"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Close()
+	if err := p.Call(ctx, "send", &sendRequest{To: "bob"}, &resp); err != ErrClosed {
+		t.Error(err)
+	}
+}
+
+func TestPoolRetry(t *testing.T) {
+	s := NewServer(newMailServer(t, nil).protocol)
+	d := &pipeDialer{server: s}
+	var calls int32
+	s.Handle("send", func(ctx context.Context, req *sendRequest) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			d.drop()
+			<-ctx.Done()
+		}
+		return req.To, nil
+	})
+	p := newTestPool(d)
+	defer p.Close()
+
+	var resp string
+	if err := p.Call(context.Background(), "send", &sendRequest{To: "bob"}, &resp); err == nil {
+		t.Fatal("call not idempotent retried")
+	} else if _, ok := err.(*ConnError); !ok {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	p.Idempotent = func(message string) bool { return message == "send" }
+	if err := p.Call(context.Background(), "send", &sendRequest{To: "bob"}, &resp); err != nil || resp != "bob" {
+		t.Fatal(resp, err)
+	}
+	if calls != 2 {
+		t.Error("calls", calls)
+	}
+
+	// dials failing more than the retries
+	d.drop()
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreInt32(&d.fails, 100)
+	p.MaxRetries = 2
+	dials := atomic.LoadInt32(&d.dials)
+	if err := p.Call(context.Background(), "send", &sendRequest{To: "bob"}, &resp); err == nil {
+		t.Fatal("no error")
+	}
+	if n := atomic.LoadInt32(&d.dials) - dials; n != 3 {
+		t.Error("dials", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	p.MinBackoff = time.Hour
+	p.MaxBackoff = time.Hour
+	if err := p.Call(ctx, "send", &sendRequest{To: "bob"}, &resp); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
+
+func TestPoolBackoff(t *testing.T) {
+	p := NewPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		// accepted, then dropped before the handshake
+		cc, sc := net.Pipe()
+		sc.Close()
+		return cc, nil
+	}, newMailServer(t, nil).protocol)
+	p.Size = 1
+	p.MaxRetries = 0
+	p.MinBackoff = 2 * time.Millisecond
+	p.MaxBackoff = time.Second
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := p.Call(context.Background(), "send", &sendRequest{}, nil); err == nil {
+			t.Fatal("call on a dropped connection")
+		}
+	}
+	// half of each backoff of 2, 4, 8, 16 and 32ms at least
+	if d := time.Since(start); d < 31*time.Millisecond {
+		t.Error("redialed after", d)
+	}
+	s := p.slots[0]
+	s.mu.Lock()
+	if s.failures != 5 {
+		t.Error("failures", s.failures)
+	}
+	s.mu.Unlock()
+	p.Close()
+}
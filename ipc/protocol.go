@@ -1,108 +1,218 @@
 package ipc
 
 import (
-        "avro"
-        "bytes"
-        "crypto/md5"
-        "encoding/binary"
-        "io"
+	"avro"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
 )
 
+// FrameBufferSize is the largest buffer of the frames written, as in the
+// Java implementation.
+const FrameBufferSize = 8192
+
+// DefaultMaxFrameSize is the largest frame read by Decode.
+const DefaultMaxFrameSize = 64 << 20
+
+// ErrFrameTooLarge is returned reading a frame larger than allowed.
+var ErrFrameTooLarge = errors.New("frame too large")
+
 // a frame contains xid(4) + blkSize(4) + blocks
 // each block contains header(4) + body
 type Frame struct {
-        Xid int32
-        bytes.Buffer
+	Xid int32
+	bytes.Buffer
 }
 
+// Encode writes the frame in buffers of at most FrameBufferSize bytes,
+// and empties it. The buffers are written in place, with a single write
+// for connections supporting it.
 func (f *Frame) Encode(w io.Writer) error {
-
-        t := struct {
-                Xid       int32
-                BlkSize   int32
-                FrameSize int32
-        }{f.Xid, 1, int32(f.Len())}
-        err := binary.Write(w, binary.BigEndian, &t)
-        if err != nil {
-                return err
-        }
-        _, err = f.WriteTo(w)
-        return err
+	b := f.Bytes()
+	n := (len(b) + FrameBufferSize - 1) / FrameBufferSize
+	head := make([]byte, 8+4*n)
+	binary.BigEndian.PutUint32(head, uint32(f.Xid))
+	binary.BigEndian.PutUint32(head[4:], uint32(n))
+	bufs := make(net.Buffers, 0, 2*n+1)
+	bufs = append(bufs, head[:8])
+	for i := 0; i < n; i++ {
+		blk := b[i*FrameBufferSize:]
+		if len(blk) > FrameBufferSize {
+			blk = blk[:FrameBufferSize]
+		}
+		size := head[8+4*i : 12+4*i]
+		binary.BigEndian.PutUint32(size, uint32(len(blk)))
+		bufs = append(bufs, size, blk)
+	}
+	if _, err := bufs.WriteTo(w); err != nil {
+		return err
+	}
+	f.Reset()
+	return nil
 }
 
+// Decode reads a frame of at most DefaultMaxFrameSize bytes.
 func (f *Frame) Decode(r io.Reader) error {
-        t := struct {
-                Xid     int32
-                BlkSize int32
-        }{}
-        err := binary.Read(r, binary.BigEndian, &t)
-        if err != nil {
-                return err
-        }
-        for i := 0; int32(i) < t.BlkSize; i++ {
-                var size int32
-                err = binary.Read(r, binary.BigEndian, &size)
-                if err != nil {
-                        return err
-                }
-                b := make([]byte, size)
-                _, err = io.ReadFull(r, b)
-                if err != nil {
-                        return err
-                }
-                f.Write(b)
-        }
-        f.Xid = t.Xid
-        return nil
+	return f.DecodeLimit(r, DefaultMaxFrameSize)
+}
+
+// DecodeLimit reads a frame, appending its buffers to f. It fails with
+// ErrFrameTooLarge if they are larger than max bytes, reading no more than
+// the sizes of the buffers that fit.
+func (f *Frame) DecodeLimit(r io.Reader, max int) error {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return err
+	}
+	count := int32(binary.BigEndian.Uint32(head[4:]))
+	if count < 0 {
+		return fmt.Errorf("frame buffer count error:%d", count)
+	}
+	total := 0
+	for i := int32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, head[4:]); err != nil {
+			return noEOF(err)
+		}
+		size := int32(binary.BigEndian.Uint32(head[4:]))
+		if size < 0 {
+			return fmt.Errorf("frame buffer size error:%d", size)
+		}
+		total += int(size)
+		if total > max {
+			return ErrFrameTooLarge
+		}
+		// grows with the data read rather than the size announced
+		if _, err := io.CopyN(&f.Buffer, r, int64(size)); err != nil {
+			return noEOF(err)
+		}
+	}
+	f.Xid = int32(binary.BigEndian.Uint32(head[:4]))
+	return nil
+}
+
+// noEOF turns io.EOF in the middle of a frame into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
 
 type HandShakeRequest struct {
-        ClientHash     [16]byte
-        ClientProtocol avro.Union
-        ServerHash     [16]byte
-        Meta           avro.Union
+	ClientHash     [16]byte
+	ClientProtocol avro.Union
+	ServerHash     [16]byte
+	Meta           avro.Union
 }
 
 func NewHandShakeRequest(proto []byte) *HandShakeRequest {
-        m := md5.Sum(proto)
-        return &HandShakeRequest{
-                m,
-                avro.MakeUnion(0, new(avro.Null), new(map[string]string)),
-                m,
-                avro.MakeUnion(0, new(avro.Null), new(map[string]string)),
-        }
+	m := md5.Sum(proto)
+	return &HandShakeRequest{
+		m,
+		avro.MakeUnion(0, new(avro.Null), new(string)),
+		m,
+		avro.MakeUnion(0, new(avro.Null), new(map[string]string)),
+	}
 }
 
+// HandShakeMatch is the HandshakeMatch enum of the handshake response.
+type HandShakeMatch int
+
 const (
-        BOTH   = 0
-        CLIENT = 1
-        NONE   = 2
+	BOTH HandShakeMatch = iota
+	CLIENT
+	NONE
 )
 
+func (HandShakeMatch) Symbols() []string {
+	return []string{"BOTH", "CLIENT", "NONE"}
+}
+
 type HandShakeResponse struct {
-        Match          int
-        ServerProtocol avro.Union
-        ServerHash     avro.Union
-        Meta           avro.Union
+	Match          HandShakeMatch
+	ServerProtocol avro.Union
+	ServerHash     avro.Union
+	Meta           avro.Union
 }
 
-func NewHandShakeResponse(match int, proto []byte) *HandShakeResponse {
-        m := md5.Sum(proto)
-        return &HandShakeResponse{
-                match,
-                avro.MakeUnion(0, new(avro.Null), new(string)),
-                avro.MakeUnion(0, new(avro.Null), &m),
-                avro.MakeUnion(0, new(avro.Null), new(map[string]string)),
-        }
+func NewHandShakeResponse(match HandShakeMatch, proto []byte) *HandShakeResponse {
+	m := md5.Sum(proto)
+	return &HandShakeResponse{
+		match,
+		avro.MakeUnion(0, new(avro.Null), new(string)),
+		avro.MakeUnion(0, new(avro.Null), &m),
+		avro.MakeUnion(0, new(avro.Null), new(map[string]string)),
+	}
 }
 
 type Request struct {
-        Meta    map[string]string
-        Method  string
-        Payload interface{}
+	Meta    map[string]string
+	Method  string
+	Payload interface{}
+}
+
+func (r Request) MarshalAvro(e *avro.Encoder) error {
+	writeMeta(e, r.Meta)
+	e.WriteString(r.Method)
+	return e.WriteValue(r.Payload)
 }
 
 type Response struct {
-        Meta  map[string]string
-        Error bool
+	Meta  map[string]string
+	Error bool
+}
+
+func (r Response) MarshalAvro(e *avro.Encoder) error {
+	writeMeta(e, r.Meta)
+	e.WriteBool(r.Error)
+	return nil
+}
+
+func (r *Response) UnmarshalAvro(d *avro.Decoder) error {
+	err := readMeta(d, &r.Meta)
+	if err != nil {
+		return err
+	}
+	r.Error, err = d.ReadBool()
+	return err
+}
+
+// writeMeta writes call metadata, a map of bytes, in key order.
+func writeMeta(e *avro.Encoder, meta map[string]string) {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e.WriteMapStart(len(keys))
+	for _, k := range keys {
+		e.WriteString(k)
+		e.WriteString(meta[k])
+	}
+	e.WriteMapEnd()
+}
+
+func readMeta(d *avro.Decoder, meta *map[string]string) error {
+	n, err := d.ReadMapStart()
+	for ; n > 0 && err == nil; n, err = d.ReadMapNext() {
+		if *meta == nil {
+			*meta = make(map[string]string, n)
+		}
+		for i := 0; i < n; i++ {
+			var k, v string
+			if k, err = d.ReadString(); err != nil {
+				return err
+			}
+			if v, err = d.ReadString(); err != nil {
+				return err
+			}
+			(*meta)[k] = v
+		}
+	}
+	return err
 }
@@ -0,0 +1,89 @@
+package ipc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	for _, n := range []int{0, 1, FrameBufferSize, FrameBufferSize + 1, 3*FrameBufferSize + 5} {
+		payload := bytes.Repeat([]byte{'x'}, n)
+		f := Frame{Xid: 7}
+		f.Write(payload)
+		var b bytes.Buffer
+		if err := f.Encode(&b); err != nil {
+			t.Fatal(err)
+		}
+		if f.Len() != 0 {
+			t.Error("frame not emptied")
+		}
+		blocks := (n + FrameBufferSize - 1) / FrameBufferSize
+		if b.Len() != 8+4*blocks+n || binary.BigEndian.Uint32(b.Bytes()[4:]) != uint32(blocks) {
+			t.Error(n, b.Len())
+		}
+		var g Frame
+		if err := g.Decode(&b); err != nil {
+			t.Fatal(err)
+		}
+		if g.Xid != 7 || !bytes.Equal(g.Bytes(), payload) {
+			t.Error(n, g.Xid, g.Len())
+		}
+	}
+}
+
+func TestFrameLimit(t *testing.T) {
+	f := Frame{Xid: 1}
+	f.Write(make([]byte, 3*FrameBufferSize))
+	var b bytes.Buffer
+	f.Encode(&b)
+	full := b.Bytes()
+	var g Frame
+	if err := g.DecodeLimit(bytes.NewReader(full), 2*FrameBufferSize); err != ErrFrameTooLarge {
+		t.Error(err)
+	}
+	if err := g.DecodeLimit(bytes.NewReader(full[:100]), len(full)); err != io.ErrUnexpectedEOF {
+		t.Error(err)
+	}
+
+	// a huge announced size is not allocated up front
+	huge := []byte{0, 0, 0, 1, 0, 0, 0, 1, 0x7f, 0xff, 0xff, 0xff, 'x'}
+	g.Reset()
+	if err := g.DecodeLimit(bytes.NewReader(huge), 1<<31-1); err != io.ErrUnexpectedEOF || g.Cap() > 1<<20 {
+		t.Error(err, g.Cap())
+	}
+	bad := []byte{0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}
+	if err := g.DecodeLimit(bytes.NewReader(bad), 100); err == nil {
+		t.Error("negative count")
+	}
+}
+
+func TestLargeMessage(t *testing.T) {
+	s := NewServer(newMailServer(t, nil).protocol)
+	s.SetMaxFrameSize(1 << 20)
+	s.Handle("send", func(ctx context.Context, req *sendRequest) (string, error) {
+		return strings.ToUpper(req.Body), nil
+	})
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := NewClient(cc, s.protocol)
+	defer c.Close()
+
+	body := strings.Repeat("large message ", 50000)
+	var resp string
+	if err := c.Call(context.Background(), "send", &sendRequest{Body: body}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp != strings.ToUpper(body) {
+		t.Error(len(resp))
+	}
+	// too large for the server, which drops the connection
+	body = strings.Repeat("x", 1<<20)
+	if err := c.Call(context.Background(), "send", &sendRequest{Body: body}, &resp); err == nil {
+		t.Error("frame too large")
+	}
+}
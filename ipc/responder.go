@@ -0,0 +1,40 @@
+package ipc
+
+import (
+	"context"
+	"fmt"
+)
+
+// GenericHandler serves a call with the parameters of the message in the
+// generic representation of avro.ReadGeneric, by name, and returns the
+// response in the same representation, nil for a null response. A
+// declared error is returned as an *ErrorRecord.
+type GenericHandler func(ctx context.Context, params map[string]interface{}) (interface{}, error)
+
+// Responder is a Server whose handlers take and return generic values,
+// to serve a protocol without Go types for its messages.
+type Responder struct {
+	*Server
+}
+
+// NewResponder returns a Responder of protocol p without handlers.
+func NewResponder(p *Protocol) *Responder {
+	return &Responder{NewServer(p)}
+}
+
+// Handle registers fn to serve the message name. Its response is written
+// as the response type of the message, or the call fails with a system
+// error.
+func (r *Responder) Handle(name string, fn GenericHandler) error {
+	m := r.protocol.Messages[name]
+	if m == nil {
+		return fmt.Errorf("unknown message:%s", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("message %s: nil handler", name)
+	}
+	r.mu.Lock()
+	r.handlers[name] = &handler{msg: m, generic: fn}
+	r.mu.Unlock()
+	return nil
+}
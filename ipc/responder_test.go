@@ -0,0 +1,67 @@
+package ipc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestResponder(t *testing.T) {
+	p := newMailServer(t, nil).protocol
+	r := NewResponder(p)
+	notified := make(chan string, 1)
+	err := r.Handle("send", func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+		switch to := params["to"].(string); to {
+		case "nobody":
+			return nil, &ErrorRecord{
+				Schema: p.Type("NotFound"),
+				Value:  map[string]interface{}{"message": "no such user", "id": int64(7)},
+			}
+		case "busy":
+			return nil, &ErrorRecord{Schema: p.Type("example.Busy"), Value: map[string]interface{}{"retry": int32(3)}}
+		case "wrong":
+			return int32(1), nil
+		default:
+			return "sent to " + to + ":" + params["body"].(string), nil
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Handle("notify", func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+		notified <- params["to"].(string)
+		return nil, nil
+	})
+	if r.Handle("unknown", nil) == nil {
+		t.Error("unknown message")
+	}
+
+	cc, sc := net.Pipe()
+	go r.ServeConn(sc)
+	c := NewClient(cc, p)
+	defer c.Close()
+	ctx := context.Background()
+
+	var resp string
+	if err := c.Call(ctx, "send", &sendRequest{"bob", "hi"}, &resp); err != nil || resp != "sent to bob:hi" {
+		t.Fatal(resp, err)
+	}
+	if err := c.Call(ctx, "send", &sendRequest{To: "nobody"}, &resp); err == nil || err.(*notFound).ID != 7 {
+		t.Error(err)
+	}
+	if e, ok := c.Call(ctx, "send", &sendRequest{To: "busy"}, &resp).(*ErrorRecord); !ok || e.Value["retry"] != int32(3) {
+		t.Error(e)
+	}
+	if _, ok := c.Call(ctx, "send", &sendRequest{To: "wrong"}, &resp).(RemoteError); !ok {
+		t.Error("response of the wrong type")
+	}
+	if err := c.Call(ctx, "notify", &notifyRequest{"x"}, nil); err != nil {
+		t.Error(err)
+	}
+	if to := <-notified; to != "x" {
+		t.Error(to)
+	}
+	if err := c.Call(ctx, "ping", nil, nil); err != RemoteError("no handler of message ping") {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,380 @@
+package ipc
+
+import (
+	"avro"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Server serves the messages of a protocol with Go functions.
+type Server struct {
+	protocol *Protocol
+
+	mu            sync.RWMutex
+	handlers      map[string]*handler
+	handShakeMeta map[string]string
+	interceptors  []ServerInterceptor
+	handle        HandlerFunc // dispatch through the interceptors
+	maxFrameSize  int
+}
+
+type handler struct {
+	msg  *Message
+	fn   reflect.Value
+	req  reflect.Type // struct of the parameters
+	resp bool         // fn returns a response
+
+	generic GenericHandler // set instead of fn by a Responder
+}
+
+// NewServer returns a Server of protocol p without handlers.
+func NewServer(p *Protocol) *Server {
+	s := &Server{
+		protocol:     p,
+		handlers:     make(map[string]*handler),
+		maxFrameSize: DefaultMaxFrameSize,
+	}
+	s.handle = s.dispatch
+	return s
+}
+
+// Use adds interceptors around the handlers of s. The first interceptor
+// added is the outermost. Calls whose request can not be decoded do not
+// reach the interceptors.
+func (s *Server) Use(interceptors ...ServerInterceptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interceptors = append(s.interceptors, interceptors...)
+	s.handle = s.dispatch
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		s.handle = s.interceptors[i].wrap(s.handle)
+	}
+}
+
+// dispatch calls the handler of message with the request req.
+func (s *Server) dispatch(ctx context.Context, message string, req interface{}) (interface{}, error) {
+	s.mu.RLock()
+	h := s.handlers[message]
+	s.mu.RUnlock()
+	if h == nil {
+		return nil, RemoteError("no handler of message " + message)
+	}
+	return h.call(ctx, req)
+}
+
+// Handle registers fn to serve the message name. fn is
+//
+//	func(ctx context.Context, req *Req) (Resp, error)
+//
+// where the fields of the struct Req are the parameters of the message
+// and Resp is its response. A message with a null response, including
+// a one-way message, may be served by
+//
+//	func(ctx context.Context, req *Req) error
+//
+// Req and Resp must match the schemas of the message as by
+// avro.Validate. An error returned by fn is sent as the declared error
+// of its type registered with RegisterError, or as a system error.
+func (s *Server) Handle(name string, fn interface{}) error {
+	m := s.protocol.Messages[name]
+	if m == nil {
+		return fmt.Errorf("unknown message:%s", name)
+	}
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != contextType ||
+		t.NumOut() < 1 || t.NumOut() > 2 || t.Out(t.NumOut()-1) != errorType {
+		return fmt.Errorf("message %s: invalid handler %s", name, t)
+	}
+	req := t.In(1)
+	if req.Kind() == reflect.Ptr {
+		req = req.Elem()
+	}
+	if err := avro.Validate(m.Request, req); err != nil {
+		return fmt.Errorf("message %s request:%s", name, err)
+	}
+	h := &handler{msg: m, fn: v, req: req, resp: t.NumOut() == 2}
+	if h.resp {
+		if m.OneWay {
+			return fmt.Errorf("one-way message %s has no response", name)
+		}
+		if err := avro.Validate(m.Response, t.Out(0)); err != nil {
+			return fmt.Errorf("message %s response:%s", name, err)
+		}
+	} else if m.Response.Type != avro.TypeNull {
+		return fmt.Errorf("message %s handler without response", name)
+	}
+
+	s.mu.Lock()
+	s.handlers[name] = h
+	s.mu.Unlock()
+	return nil
+}
+
+// SetHandShakeMeta sets the metadata sent in the responses to handshakes.
+func (s *Server) SetHandShakeMeta(meta map[string]string) {
+	s.mu.Lock()
+	s.handShakeMeta = meta
+	s.mu.Unlock()
+}
+
+// SetMaxFrameSize sets the size of the largest frame read from clients,
+// DefaultMaxFrameSize by default. A connection sending a larger frame is
+// closed.
+func (s *Server) SetMaxFrameSize(n int) {
+	s.mu.Lock()
+	s.maxFrameSize = n
+	s.mu.Unlock()
+}
+
+// Serve accepts connections on l and serves each in a new goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves the calls on rwc until it is closed. Calls are served
+// concurrently. If rwc is a *tls.Conn, its handshake is done first and
+// handlers get its state with TLSStateFromContext.
+func (s *Server) ServeConn(rwc io.ReadWriteCloser) {
+	s.mu.RLock()
+	c := &serverConn{server: s, rwc: rwc, maxFrameSize: s.maxFrameSize}
+	s.mu.RUnlock()
+	if tc, ok := rwc.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			rwc.Close()
+			return
+		}
+		st := tc.ConnectionState()
+		c.tls = &st
+	}
+	c.serve()
+}
+
+type serverConn struct {
+	server        *Server
+	rwc           io.ReadWriteCloser
+	handShake     bool
+	handShakeMeta map[string]string // of the client
+	tls           *tls.ConnectionState
+	maxFrameSize  int
+
+	wmu sync.Mutex // guards writes to rwc
+	wg  sync.WaitGroup
+}
+
+func (c *serverConn) serve() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		c.wg.Wait()
+		c.rwc.Close()
+	}()
+	for {
+		var f Frame
+		if err := f.DecodeLimit(c.rwc, c.maxFrameSize); err != nil {
+			return
+		}
+		d := avro.NewBytesDecoder(f.Bytes())
+
+		// the response to a handshake, sent with the response to the call
+		var hs *HandShakeResponse
+		if !c.handShake {
+			req := NewHandShakeRequest(nil)
+			if err := d.Decode(req); err != nil {
+				return
+			}
+			hs = c.server.handShake(req)
+			if hs.Match == NONE {
+				if c.write(f.Xid, hs, nil) != nil {
+					return
+				}
+				continue
+			}
+			c.handShake = true
+			c.handShakeMeta = unionMeta(req.Meta)
+		}
+
+		var req Request
+		if err := readMeta(d, &req.Meta); err != nil {
+			return
+		}
+		name, err := d.ReadString()
+		if err != nil {
+			return
+		}
+		if name == "" {
+			// a handshake without call
+			if hs != nil && c.write(f.Xid, hs, nil) != nil {
+				return
+			}
+			continue
+		}
+		req.Method = name
+		c.wg.Add(1)
+		go func(xid int32) {
+			defer c.wg.Done()
+			c.call(ctx, xid, hs, &req, d)
+		}(f.Xid)
+	}
+}
+
+// call serves a call whose parameters are read from d. The handler gets
+// the call in ctx.
+func (c *serverConn) call(ctx context.Context, xid int32, hs *HandShakeResponse, req *Request, d *avro.Decoder) {
+	info := &callInfo{
+		method:        req.Method,
+		meta:          req.Meta,
+		handShakeMeta: c.handShakeMeta,
+		tls:           c.tls,
+	}
+	ctx = context.WithValue(ctx, callInfoKey{}, info)
+
+	c.server.mu.RLock()
+	h := c.server.handlers[req.Method]
+	handle := c.server.handle
+	c.server.mu.RUnlock()
+
+	m := c.server.protocol.Messages[req.Method]
+	var params, resp interface{}
+	var err error
+	if h != nil {
+		params, err = h.decode(d)
+	}
+	if err == nil {
+		resp, err = handle(ctx, req.Method, params)
+	}
+	if m != nil && m.OneWay && hs == nil {
+		// no response, unless needed to complete the handshake
+		return
+	}
+	c.write(xid, hs, func(e *avro.Encoder) error {
+		writeMeta(e, info.responseMeta())
+		e.WriteBool(err != nil)
+		if err != nil {
+			return writeError(e, m, err)
+		}
+		if h == nil {
+			return nil
+		}
+		return h.write(e, resp)
+	})
+}
+
+// decode reads the request of a call, of the type of the parameter of fn
+// or generic.
+func (h *handler) decode(d *avro.Decoder) (interface{}, error) {
+	if h.generic != nil {
+		req, err := d.ReadGeneric(h.msg.Request)
+		if err != nil {
+			return nil, RemoteError("decode request:" + err.Error())
+		}
+		return req, nil
+	}
+	req := reflect.New(h.req)
+	if err := d.Decode(req.Interface()); err != nil {
+		return nil, RemoteError("decode request:" + err.Error())
+	}
+	if h.fn.Type().In(1).Kind() != reflect.Ptr {
+		req = req.Elem()
+	}
+	return req.Interface(), nil
+}
+
+func (h *handler) call(ctx context.Context, req interface{}) (interface{}, error) {
+	if h.generic != nil {
+		params, ok := req.(map[string]interface{})
+		if !ok {
+			return nil, RemoteError(fmt.Sprintf("message %s: invalid request %T", h.msg.Name, req))
+		}
+		return h.generic(ctx, params)
+	}
+	in := reflect.ValueOf(req)
+	if !in.IsValid() || in.Type() != h.fn.Type().In(1) {
+		return nil, RemoteError(fmt.Sprintf("message %s: invalid request %T", h.msg.Name, req))
+	}
+	out := h.fn.Call([]reflect.Value{reflect.ValueOf(ctx), in})
+	if err, _ := out[len(out)-1].Interface().(error); err != nil {
+		return nil, err
+	}
+	if h.resp {
+		return out[0].Interface(), nil
+	}
+	return nil, nil
+}
+
+// write writes the response resp of a call.
+func (h *handler) write(e *avro.Encoder, resp interface{}) error {
+	if h.generic != nil {
+		return e.WriteGeneric(h.msg.Response, resp)
+	}
+	if resp == nil {
+		return nil
+	}
+	return e.WriteValue(resp)
+}
+
+// write writes a frame of the handshake response hs, if not nil, and
+// what body writes. If body fails, the call fails with a system error.
+func (c *serverConn) write(xid int32, hs *HandShakeResponse, body func(e *avro.Encoder) error) error {
+	f := Frame{Xid: xid}
+	e := avro.NewEncoder(&f)
+	if hs != nil {
+		if err := e.Encode(hs); err != nil {
+			return err
+		}
+	}
+	if body != nil {
+		if err := body(e); err != nil {
+			// drop what body wrote
+			e.Reset(&f)
+			writeMeta(e, nil)
+			e.WriteBool(true)
+			e.WriteUnionIndex(0)
+			e.WriteString("encode response:" + err.Error())
+		}
+		if err := e.Flush(); err != nil {
+			return err
+		}
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return f.Encode(c.rwc)
+}
+
+// handShake returns the response to a handshake request. The client must
+// use the protocol of the server, as requests are not resolved against
+// other protocols.
+func (s *Server) handShake(req *HandShakeRequest) *HandShakeResponse {
+	p := s.protocol
+	rep := NewHandShakeResponse(NONE, p.text)
+	s.mu.RLock()
+	rep.Meta = metaUnion(s.handShakeMeta)
+	s.mu.RUnlock()
+	if req.ClientHash == p.MD5 {
+		rep.Match = BOTH
+		if req.ServerHash == p.MD5 {
+			return rep
+		}
+		rep.Match = CLIENT
+	}
+	text := string(p.text)
+	rep.ServerProtocol = avro.MakeUnion(1, new(avro.Null), &text)
+	rep.ServerHash = avro.MakeUnion(1, new(avro.Null), &p.MD5)
+	return rep
+}
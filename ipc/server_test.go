@@ -0,0 +1,127 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+type notifyRequest struct {
+	To string `avro:"to"`
+}
+
+func newMailServer(t *testing.T, notified chan<- string) *Server {
+	p, err := ParseProtocol([]byte(mailProto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(p)
+	err = s.Handle("send", func(ctx context.Context, req *sendRequest) (string, error) {
+		switch req.To {
+		case "nobody":
+			return "", &notFound{"no such user", 7}
+		case "crash":
+			return "", errors.New("server crashed")
+		}
+		return "sent to " + req.To, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Handle("notify", func(ctx context.Context, req notifyRequest) error {
+		notified <- req.To
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServer(t *testing.T) {
+	notified := make(chan string, 10)
+	s := newMailServer(t, notified)
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := rpc.NewClientWithCodec(NewClientCodec(cc, []byte(mailProto)))
+	defer c.Close()
+
+	var resp string
+	if err := c.Call("send", sendRequest{To: "bob"}, &resp); err != nil || resp != "sent to bob" {
+		t.Fatal(resp, err)
+	}
+	if err := c.Call("send", sendRequest{To: "nobody"}, &resp); err != rpc.ServerError("no such user") {
+		t.Error(err)
+	}
+	if err := c.Call("send", sendRequest{To: "crash"}, &resp); err != rpc.ServerError("server crashed") {
+		t.Error(err)
+	}
+	if err := c.Call("ping", struct{}{}, nil); err != rpc.ServerError("no handler of message ping") {
+		t.Error(err)
+	}
+	if err := c.Call("unknown", struct{}{}, nil); err == nil {
+		t.Error("unknown message")
+	}
+}
+
+func TestServerOneWay(t *testing.T) {
+	notified := make(chan string, 10)
+	s := newMailServer(t, notified)
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := rpc.NewClientWithCodec(NewClientCodec(cc, []byte(mailProto)))
+	defer c.Close()
+
+	// the first call also completes the handshake
+	for _, to := range []string{"a", "b", "c"} {
+		if err := c.Call("notify", notifyRequest{to}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, to := range []string{"a", "b", "c"} {
+		select {
+		case got := <-notified:
+			if got != to {
+				t.Error(got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("not notified")
+		}
+	}
+	// responses still match their calls
+	var resp string
+	if err := c.Call("send", sendRequest{To: "bob"}, &resp); err != nil || resp != "sent to bob" {
+		t.Fatal(resp, err)
+	}
+}
+
+func TestServerHandshakeMismatch(t *testing.T) {
+	s := newMailServer(t, nil)
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c := rpc.NewClientWithCodec(NewClientCodec(cc, []byte(`{"protocol": "Other", "messages": {}}`)))
+	defer c.Close()
+
+	var resp string
+	if err := c.Call("send", sendRequest{To: "bob"}, &resp); err != rpc.ServerError("handshake error") {
+		t.Error(err)
+	}
+}
+
+func TestServerHandle(t *testing.T) {
+	s := newMailServer(t, nil)
+	bad := map[string]interface{}{
+		"unknown": func(ctx context.Context, req *sendRequest) error { return nil },
+		"send":    func(ctx context.Context, req *notifyRequest) (string, error) { return "", nil },
+		"notify":  func(ctx context.Context, req *notifyRequest) (string, error) { return "", nil },
+		"ping":    func(req *struct{}) error { return nil },
+	}
+	for name, fn := range bad {
+		if err := s.Handle(name, fn); err == nil {
+			t.Errorf("%s: %T is not a valid handler", name, fn)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package ipc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/rpc"
+)
+
+// DialTLS is Dial over a TLS connection made with config.
+func DialTLS(addr string, proto []byte, config *tls.Config) (*rpc.Client, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClientWithCodec(NewClientCodec(conn, proto)), nil
+}
+
+// DialPoolTLS returns a Pool of TLS connections to addr made with config.
+func DialPoolTLS(addr string, p *Protocol, config *tls.Config) *Pool {
+	d := tls.Dialer{Config: config}
+	return NewPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	}, p)
+}
+
+// ServeTLS accepts connections on l and serves each over TLS made with
+// config in a new goroutine. To authenticate clients by certificate,
+// config.ClientAuth is tls.RequireAndVerifyClientCert and handlers get
+// the certificate with PeerCertificate.
+func (s *Server) ServeTLS(l net.Listener, config *tls.Config) error {
+	return s.Serve(tls.NewListener(l, config))
+}
+
+// TLSStateFromContext returns the state of the TLS connection a Server
+// handler serves ctx on, nil if it is not TLS.
+func TLSStateFromContext(ctx context.Context) *tls.ConnectionState {
+	if c := callInfoOf(ctx); c != nil {
+		return c.tls
+	}
+	return nil
+}
+
+// PeerCertificate returns the verified certificate of the client a
+// Server handler serves ctx for, nil if the client has none.
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	st := TLSStateFromContext(ctx)
+	if st == nil || len(st.VerifiedChains) == 0 {
+		return nil
+	}
+	return st.VerifiedChains[0][0]
+}
@@ -0,0 +1,100 @@
+package ipc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+// newCert returns a certificate of name signed by parent, or self-signed
+// if parent is nil.
+func newCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLS(t *testing.T) {
+	ca := newCert(t, "ca", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	serverCert := newCert(t, "server", &ca)
+	clientCert := newCert(t, "alice", &ca)
+
+	p := newMailServer(t, nil).protocol
+	s := NewServer(p)
+	s.Handle("send", func(ctx context.Context, req *sendRequest) (string, error) {
+		cert := PeerCertificate(ctx)
+		if cert == nil {
+			return "", RemoteError("unauthorized")
+		}
+		return cert.Subject.CommonName, nil
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.ServeTLS(l, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    roots,
+	})
+	addr := l.Addr().String()
+
+	pool := DialPoolTLS(addr, p, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}})
+	defer pool.Close()
+	var resp string
+	if err := pool.Call(context.Background(), "send", &sendRequest{}, &resp); err != nil || resp != "alice" {
+		t.Fatal(resp, err)
+	}
+
+	c, err := DialTLS(addr, []byte(mailProto), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Call("send", sendRequest{}, &resp); err != rpc.ServerError("unauthorized") {
+		t.Error(err)
+	}
+
+	// the server is not trusted
+	if _, err := DialTLS(addr, []byte(mailProto), &tls.Config{}); err == nil {
+		t.Error("untrusted server")
+	}
+}
@@ -0,0 +1,283 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ToJSON returns the JSON encoding of the specification of the generic
+// value v of schema s: bytes and fixed are strings of code points 0-255,
+// and a union value other than null is an object keyed by the branch
+// name, e.g. {"string": "a"}.
+func ToJSON(s *Schema, v interface{}) ([]byte, error) {
+	j, err := toJSON(s, v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
+
+func toJSON(s *Schema, v interface{}) (interface{}, error) {
+	switch s.Type {
+	case TypeFloat, TypeDouble:
+		f, ok := genericFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("%T for %s", v, s.Type)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%v can not be encoded in JSON", f)
+		}
+		if s.Type == TypeFloat {
+			return json.Number(fmt.Sprint(float32(f))), nil
+		}
+		return f, nil
+	case TypeBytes, TypeFixed:
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%T for %s", v, s.Type)
+		}
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r), nil
+	case TypeRecord:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%T for record %s", v, s.Name)
+		}
+		o := make(object, len(s.Fields))
+		for i, f := range s.Fields {
+			fv, err := toJSON(f.Type, m[f.Name])
+			if err != nil {
+				return nil, fmt.Errorf("encode %s:%s", f.Name, err)
+			}
+			o[i] = member{f.Name, fv}
+		}
+		return o, nil
+	case TypeArray:
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%T for array", v)
+		}
+		items := make([]interface{}, len(a))
+		for i, item := range a {
+			j, err := toJSON(s.Items, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = j
+		}
+		return items, nil
+	case TypeMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%T for map", v)
+		}
+		o := make(object, 0, len(m))
+		for _, k := range sortedKeys(m) {
+			j, err := toJSON(s.Values, m[k])
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, member{k, j})
+		}
+		return o, nil
+	case TypeUnion:
+		idx := ResolveUnion(s, v)
+		if idx == -1 {
+			return nil, unionError(v)
+		}
+		if br, ok := v.(Branch); ok {
+			v = br.Value
+		}
+		b := s.Branches[idx]
+		if b.Type == TypeNull {
+			return nil, nil
+		}
+		j, err := toJSON(b, v)
+		if err != nil {
+			return nil, err
+		}
+		return object{{typeName(b), j}}, nil
+	}
+	if !genericMatches(s, v) {
+		return nil, fmt.Errorf("%T for %s", v, typeName(s))
+	}
+	return v, nil
+}
+
+// FromJSON parses the JSON encoding of a value of schema s, as written by
+// ToJSON, into its generic representation. Missing record fields take
+// their default.
+func FromJSON(s *Schema, b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var j interface{}
+	if err := dec.Decode(&j); err != nil {
+		return nil, err
+	}
+	return fromJSON(s, j, false)
+}
+
+// fromJSON converts the decoded JSON j. A default value of a union is a
+// value of its first branch instead of an object keyed by branch name.
+func fromJSON(s *Schema, j interface{}, isDefault bool) (interface{}, error) {
+	switch s.Type {
+	case TypeNull:
+		if j != nil {
+			return nil, fmt.Errorf("%v for null", j)
+		}
+		return nil, nil
+	case TypeBoolean:
+		if b, ok := j.(bool); ok {
+			return b, nil
+		}
+	case TypeInt, TypeLong:
+		n, ok := j.(json.Number)
+		if !ok {
+			break
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return nil, err
+		}
+		if s.Type == TypeLong {
+			return i, nil
+		}
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, fmt.Errorf("value %d overflows int", i)
+		}
+		return int32(i), nil
+	case TypeFloat, TypeDouble:
+		n, ok := j.(json.Number)
+		if !ok {
+			break
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		if s.Type == TypeFloat {
+			return float32(f), nil
+		}
+		return f, nil
+	case TypeString:
+		if str, ok := j.(string); ok {
+			return str, nil
+		}
+	case TypeBytes, TypeFixed:
+		str, ok := j.(string)
+		if !ok {
+			break
+		}
+		b := make([]byte, 0, len(str))
+		for _, r := range str {
+			if r > 0xff || r == utf8.RuneError {
+				return nil, fmt.Errorf("invalid byte %U in %s", r, s.Type)
+			}
+			b = append(b, byte(r))
+		}
+		if s.Type == TypeFixed && len(b) != s.Size {
+			return nil, fmt.Errorf("%d bytes for fixed %s of size %d", len(b), s.Name, s.Size)
+		}
+		return b, nil
+	case TypeEnum:
+		sym, ok := j.(string)
+		if !ok {
+			break
+		}
+		if enumIndex(s.Symbols, sym) == -1 {
+			return nil, fmt.Errorf("enum %s unknown symbol:%q", s.Name, sym)
+		}
+		return sym, nil
+	case TypeRecord:
+		o, ok := j.(map[string]interface{})
+		if !ok {
+			break
+		}
+		m := make(map[string]interface{}, len(s.Fields))
+		for _, f := range s.Fields {
+			fj, ok := o[f.Name]
+			fromDefault := !ok
+			if fromDefault {
+				if !f.HasDefault {
+					return nil, fmt.Errorf("record %s missing field:%s", s.Name, f.Name)
+				}
+				fj = f.Default
+			}
+			v, err := fromJSON(f.Type, fj, fromDefault || isDefault)
+			if err != nil {
+				return nil, fmt.Errorf("decode %s:%s", f.Name, err)
+			}
+			m[f.Name] = v
+		}
+		return m, nil
+	case TypeArray:
+		a, ok := j.([]interface{})
+		if !ok {
+			break
+		}
+		items := make([]interface{}, len(a))
+		for i, item := range a {
+			v, err := fromJSON(s.Items, item, isDefault)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	case TypeMap:
+		o, ok := j.(map[string]interface{})
+		if !ok {
+			break
+		}
+		m := make(map[string]interface{}, len(o))
+		for k, vj := range o {
+			v, err := fromJSON(s.Values, vj, isDefault)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case TypeUnion:
+		if len(s.Branches) == 0 {
+			break
+		}
+		if isDefault {
+			v, err := fromJSON(s.Branches[0], j, true)
+			if err != nil {
+				return nil, err
+			}
+			return MakeBranch(s, 0, v), nil
+		}
+		if j == nil {
+			for _, b := range s.Branches {
+				if b.Type == TypeNull {
+					return nil, nil
+				}
+			}
+			break
+		}
+		o, ok := j.(map[string]interface{})
+		if !ok || len(o) != 1 {
+			break
+		}
+		for name, vj := range o {
+			idx := branchIndex(s, name)
+			if idx == -1 {
+				return nil, fmt.Errorf("union has no branch %s", name)
+			}
+			v, err := fromJSON(s.Branches[idx], vj, false)
+			if err != nil {
+				return nil, err
+			}
+			return MakeBranch(s, idx, v), nil
+		}
+	}
+	return nil, fmt.Errorf("%v for %s", j, typeName(s))
+}
@@ -0,0 +1,53 @@
+package avro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	b, err := ToJSON(nodeSchema, nodeValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"id":1,"tag":{"string":"a"},"raw":"ÿ\u0000","kind":"A","attrs":{"x":1.5},` +
+		`"next":{"ns.Node":{"id":2,"tag":null,"raw":"\u0001\u0002","kind":"B","attrs":{},"next":null}}}`
+	if string(b) != expect {
+		t.Errorf("%s != %s", b, expect)
+	}
+	v, err := FromJSON(nodeSchema, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, nodeValue) {
+		t.Errorf("%v != %v", v, nodeValue)
+	}
+}
+
+func TestFromJSONDefault(t *testing.T) {
+	v, err := FromJSON(nodeSchema, []byte(`{"id": 3, "raw": "ab", "next": {"Node": {"id": 4, "raw": "cd"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := v.(map[string]interface{})
+	if m["kind"] != "B" || m["tag"] != nil || len(m["attrs"].(map[string]interface{})) != 0 {
+		t.Error(m)
+	}
+	if next := m["next"].(map[string]interface{}); next["id"] != int64(4) {
+		t.Error(next)
+	}
+
+	bad := []string{
+		`{"raw": "ab"}`,
+		`{"id": 1, "raw": "abc"}`,
+		`{"id": 1, "raw": "ab", "tag": "a"}`,
+		`{"id": 1, "raw": "ab", "tag": {"int": 1}}`,
+		`{"id": 1, "raw": "ab", "kind": "C"}`,
+		`{"id": 1.5, "raw": "ab"}`,
+	}
+	for _, b := range bad {
+		if _, err := FromJSON(nodeSchema, []byte(b)); err == nil {
+			t.Errorf("%s is invalid", b)
+		}
+	}
+}
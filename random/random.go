@@ -0,0 +1,253 @@
+// Package random generates random values of avro schemas, for load tests
+// and fuzzing.
+package random
+
+import (
+	"avro"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"math/rand"
+)
+
+const (
+	// DefaultMaxLength is the default bound of the length of generated
+	// strings, bytes, arrays and maps.
+	DefaultMaxLength = 8
+	// DefaultMaxDepth is the default bound of the nesting of generated
+	// values.
+	DefaultMaxDepth = 8
+)
+
+// maxTime is the bound of generated dates and timestamps,
+// 2100-01-01T00:00:00Z in seconds.
+const maxTime = 4102444800
+
+// Generator generates values in the generic representation of
+// avro.Decoder.ReadGeneric.
+type Generator struct {
+	// MaxLength bounds the length of strings, bytes, arrays and maps.
+	MaxLength int
+	// MaxDepth bounds the nesting of values. Beyond it arrays and maps are
+	// empty and unions take a branch which is not a record if they have
+	// one, so recursive records end.
+	MaxDepth int
+
+	rng *rand.Rand
+}
+
+// New returns a Generator drawing from rng.
+func New(rng *rand.Rand) *Generator {
+	return &Generator{
+		MaxLength: DefaultMaxLength,
+		MaxDepth:  DefaultMaxDepth,
+		rng:       rng,
+	}
+}
+
+// Generate returns a random value of schema s in the generic
+// representation, using the default bounds.
+func Generate(s *avro.Schema, rng *rand.Rand) interface{} {
+	return New(rng).Generate(s)
+}
+
+// Generate returns a random value of schema s in the generic
+// representation. An enum without symbols or a union without branches
+// has no values, so Generate returns nil for them, which fails to encode.
+func (g *Generator) Generate(s *avro.Schema) interface{} {
+	return g.generate(s, 0)
+}
+
+// GenerateInto stores a random value of schema s in x, which must be a
+// pointer to a Go value matching s as by avro.Validate.
+func (g *Generator) GenerateInto(s *avro.Schema, x interface{}) error {
+	var buf bytes.Buffer
+	e := avro.NewEncoder(&buf)
+	if err := e.WriteGeneric(s, g.Generate(s)); err != nil {
+		return err
+	}
+	if err := e.Flush(); err != nil {
+		return err
+	}
+	return avro.Unmarshal(buf.Bytes(), x)
+}
+
+func (g *Generator) generate(s *avro.Schema, depth int) interface{} {
+	if v, ok := g.logical(s); ok {
+		return v
+	}
+	switch s.Type {
+	case avro.TypeBoolean:
+		return g.rng.Intn(2) == 1
+	case avro.TypeInt:
+		return int32(g.rng.Uint32())
+	case avro.TypeLong:
+		return int64(g.rng.Uint64())
+	case avro.TypeFloat:
+		return float32(g.rng.NormFloat64() * 1000)
+	case avro.TypeDouble:
+		return g.rng.NormFloat64() * 1000
+	case avro.TypeBytes:
+		b := make([]byte, g.length(depth))
+		g.rng.Read(b)
+		return b
+	case avro.TypeString:
+		return g.str(g.length(depth))
+	case avro.TypeRecord:
+		m := make(map[string]interface{}, len(s.Fields))
+		for _, f := range s.Fields {
+			m[f.Name] = g.generate(f.Type, depth+1)
+		}
+		return m
+	case avro.TypeEnum:
+		if len(s.Symbols) == 0 {
+			return nil
+		}
+		return s.Symbols[g.rng.Intn(len(s.Symbols))]
+	case avro.TypeArray:
+		a := make([]interface{}, g.length(depth))
+		for i := range a {
+			a[i] = g.generate(s.Items, depth+1)
+		}
+		return a
+	case avro.TypeMap:
+		n := g.length(depth)
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			m[g.str(1+g.rng.Intn(DefaultMaxLength))] = g.generate(s.Values, depth+1)
+		}
+		return m
+	case avro.TypeUnion:
+		if len(s.Branches) == 0 {
+			return nil
+		}
+		idx := g.rng.Intn(len(s.Branches))
+		if depth >= g.MaxDepth {
+			for i, b := range s.Branches {
+				if b.Type != avro.TypeRecord {
+					idx = i
+					break
+				}
+			}
+		}
+		return avro.MakeBranch(s, idx, g.generate(s.Branches[idx], depth+1))
+	case avro.TypeFixed:
+		b := make([]byte, s.Size)
+		g.rng.Read(b)
+		return b
+	}
+	return nil
+}
+
+// length returns the length of a string, bytes, array or map at depth.
+func (g *Generator) length(depth int) int {
+	if depth >= g.MaxDepth || g.MaxLength <= 0 {
+		return 0
+	}
+	return g.rng.Intn(g.MaxLength + 1)
+}
+
+func (g *Generator) str(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[g.rng.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// logical returns a value of the logical type of s, or false if s has no
+// logical type or it does not apply to the type of s.
+func (g *Generator) logical(s *avro.Schema) (interface{}, bool) {
+	switch s.LogicalType {
+	case "decimal":
+		if s.Type != avro.TypeBytes && s.Type != avro.TypeFixed || s.Precision <= 0 {
+			break
+		}
+		return g.decimal(s), true
+	case "uuid":
+		if s.Type != avro.TypeString {
+			break
+		}
+		var b [16]byte
+		g.rng.Read(b[:])
+		b[6] = b[6]&0x0f | 0x40 // version 4
+		b[8] = b[8]&0x3f | 0x80 // variant 10
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:]), true
+	case "date":
+		if s.Type != avro.TypeInt {
+			break
+		}
+		return int32(g.rng.Int63n(maxTime / 86400)), true
+	case "time-millis":
+		if s.Type != avro.TypeInt {
+			break
+		}
+		return int32(g.rng.Int63n(86400 * 1e3)), true
+	case "time-micros":
+		if s.Type != avro.TypeLong {
+			break
+		}
+		return g.rng.Int63n(86400 * 1e6), true
+	case "timestamp-millis", "local-timestamp-millis":
+		if s.Type != avro.TypeLong {
+			break
+		}
+		return g.rng.Int63n(maxTime * 1e3), true
+	case "timestamp-micros", "local-timestamp-micros":
+		if s.Type != avro.TypeLong {
+			break
+		}
+		return g.rng.Int63n(maxTime * 1e6), true
+	case "timestamp-nanos", "local-timestamp-nanos":
+		if s.Type != avro.TypeLong {
+			break
+		}
+		return g.rng.Int63n(maxTime * 1e9), true
+	case "duration":
+		if s.Type != avro.TypeFixed || s.Size != 12 {
+			break
+		}
+		b := make([]byte, 12)
+		binary.LittleEndian.PutUint32(b, uint32(g.rng.Intn(1200)))
+		binary.LittleEndian.PutUint32(b[4:], uint32(g.rng.Intn(31)))
+		binary.LittleEndian.PutUint32(b[8:], uint32(g.rng.Intn(86400*1e3)))
+		return b, true
+	}
+	return nil, false
+}
+
+// decimal returns the big-endian two's complement of an unscaled value
+// of at most s.Precision digits.
+func (g *Generator) decimal(s *avro.Schema) []byte {
+	precision := s.Precision
+	if s.Type == avro.TypeFixed {
+		// the most digits the fixed can hold
+		if max := int(float64(8*s.Size-1) * 0.30103); precision > max {
+			precision = max
+		}
+	}
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	n := new(big.Int).Rand(g.rng, limit)
+	neg := g.rng.Intn(2) == 1
+	if neg {
+		n.Neg(n)
+	}
+
+	size := len(n.Bytes()) + 1
+	if s.Type == avro.TypeFixed {
+		size = s.Size
+	}
+	// two's complement: add 2^(8*size) to negative values
+	if n.Sign() < 0 {
+		n.Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*size)))
+	}
+	b := make([]byte, size)
+	n.FillBytes(b)
+	if s.Type == avro.TypeBytes && size > 1 && (b[0] == 0 && b[1] < 0x80 || b[0] == 0xff && b[1] >= 0x80) {
+		// drop the redundant sign byte
+		b = b[1:]
+	}
+	return b
+}
@@ -0,0 +1,164 @@
+package random
+
+import (
+	"avro"
+	"bytes"
+	"io"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+var schema = avro.MustParseSchema(`{"type": "record", "name": "Node", "fields": [
+        {"name": "id", "type": "long"},
+        {"name": "ok", "type": "boolean"},
+        {"name": "score", "type": "double"},
+        {"name": "name", "type": "string"},
+        {"name": "raw", "type": "bytes"},
+        {"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 4}},
+        {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}},
+        {"name": "tags", "type": {"type": "array", "items": "string"}},
+        {"name": "attrs", "type": {"type": "map", "values": "int"}},
+        {"name": "id2", "type": {"type": "string", "logicalType": "uuid"}},
+        {"name": "day", "type": {"type": "int", "logicalType": "date"}},
+        {"name": "at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+        {"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}},
+        {"name": "total", "type": {"type": "fixed", "name": "Dec", "size": 3, "logicalType": "decimal", "precision": 20}},
+        {"name": "children", "type": {"type": "array", "items": "Node"}},
+        {"name": "next", "type": ["null", "Node"]}
+]}`)
+
+func TestGenerate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		v := Generate(schema, rng)
+		var buf bytes.Buffer
+		e := avro.NewEncoder(&buf)
+		if err := e.WriteGeneric(schema, v); err != nil {
+			t.Fatal(err)
+		}
+		e.Flush()
+		out, err := avro.NewBytesDecoder(buf.Bytes()).ReadGeneric(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, out) {
+			t.Fatalf("%v != %v", out, v)
+		}
+		checkLogical(t, v.(map[string]interface{}))
+	}
+}
+
+func checkLogical(t *testing.T, m map[string]interface{}) {
+	if id := m["id2"].(string); len(id) != 36 || id[14] != '4' {
+		t.Error("uuid", id)
+	}
+	if day := m["day"].(int32); day < 0 || day > maxTime/86400 {
+		t.Error("date", day)
+	}
+	if at := m["at"].(int64); at < 0 || at > maxTime*1e3 {
+		t.Error("timestamp", at)
+	}
+	price := decimal(m["price"].([]byte))
+	if limit := big.NewInt(1e6); price.CmpAbs(limit) >= 0 {
+		t.Error("decimal", price)
+	}
+	if total := m["total"].([]byte); len(total) != 3 {
+		t.Error("fixed decimal", total)
+	}
+	for _, c := range m["children"].([]interface{}) {
+		checkLogical(t, c.(map[string]interface{}))
+	}
+}
+
+func decimal(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0] >= 0x80 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return n
+}
+
+func TestGenerateBounds(t *testing.T) {
+	g := New(rand.New(rand.NewSource(2)))
+	g.MaxLength = 2
+	g.MaxDepth = 3
+	var depth func(v interface{}) int
+	depth = func(v interface{}) int {
+		d := 0
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for _, e := range v {
+				if n := depth(e); n > d {
+					d = n
+				}
+			}
+			d++
+		case []interface{}:
+			if len(v) > 2 {
+				t.Error("array longer than MaxLength", len(v))
+			}
+			for _, e := range v {
+				if n := depth(e); n > d {
+					d = n
+				}
+			}
+			d++
+		}
+		return d
+	}
+	for i := 0; i < 100; i++ {
+		// a record, its children and their nested fields
+		if d := depth(g.Generate(schema)); d > 2*g.MaxDepth+2 {
+			t.Fatal("too deep", d)
+		}
+	}
+}
+
+type node struct {
+	ID   int64
+	Name string
+	Tags []string
+	Next *node
+}
+
+func TestGenerateInto(t *testing.T) {
+	s, err := avro.SchemaOf(node{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(rand.New(rand.NewSource(3)))
+	var n node
+	if err := g.GenerateInto(s, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.ID == 0 && n.Name == "" {
+		t.Error("zero value", n)
+	}
+}
+
+func TestGenerateSeed(t *testing.T) {
+	a := Generate(schema, rand.New(rand.NewSource(4)))
+	b := Generate(schema, rand.New(rand.NewSource(4)))
+	if !reflect.DeepEqual(a, b) {
+		t.Error("same seed gave different values")
+	}
+}
+
+func TestGenerateNoValues(t *testing.T) {
+	for _, src := range []string{
+		`{"type": "enum", "name": "e", "symbols": []}`,
+		`[]`,
+		`{"type": "record", "name": "r", "fields": [{"name": "u", "type": []}]}`,
+	} {
+		s, err := avro.ParseSchema([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := Generate(s, rand.New(rand.NewSource(5)))
+		if err := avro.NewEncoder(io.Discard).WriteGeneric(s, v); err == nil {
+			t.Errorf("%s: encoded %v", src, v)
+		}
+	}
+}
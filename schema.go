@@ -0,0 +1,443 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Type is the type of a schema.
+type Type string
+
+const (
+	TypeNull    Type = "null"
+	TypeBoolean Type = "boolean"
+	TypeInt     Type = "int"
+	TypeLong    Type = "long"
+	TypeFloat   Type = "float"
+	TypeDouble  Type = "double"
+	TypeBytes   Type = "bytes"
+	TypeString  Type = "string"
+	TypeRecord  Type = "record"
+	TypeEnum    Type = "enum"
+	TypeArray   Type = "array"
+	TypeMap     Type = "map"
+	TypeUnion   Type = "union"
+	TypeFixed   Type = "fixed"
+)
+
+func isPrimitive(t Type) bool {
+	switch t {
+	case TypeNull, TypeBoolean, TypeInt, TypeLong, TypeFloat, TypeDouble, TypeBytes, TypeString:
+		return true
+	}
+	return false
+}
+
+// Schema is a parsed avro schema. Named types are shared, so a recursive
+// record refers to itself.
+type Schema struct {
+	Type Type
+
+	// Name is the full name of a record, enum or fixed.
+	Name    string
+	Aliases []string
+	Doc     string
+
+	// record, IsError is set for the error records of a protocol
+	Fields  []*Field
+	IsError bool
+
+	// enum, EnumDefault is the symbol used for unknown symbols
+	Symbols     []string
+	EnumDefault string
+
+	// array and map
+	Items  *Schema
+	Values *Schema
+
+	// union
+	Branches []*Schema
+
+	// fixed
+	Size int
+
+	// LogicalType annotates the type, e.g. "timestamp-millis" on a long.
+	LogicalType string
+	Precision   int
+	Scale       int
+}
+
+type Field struct {
+	Name    string
+	Aliases []string
+	Doc     string
+	Type    *Schema
+	// Default is the default value as decoded from JSON, with numbers
+	// as json.Number. A null default is nil with HasDefault set.
+	Default    interface{}
+	HasDefault bool
+}
+
+// Namespace returns the namespace of a named type.
+func (s *Schema) Namespace() string {
+	if i := strings.LastIndex(s.Name, "."); i != -1 {
+		return s.Name[:i]
+	}
+	return ""
+}
+
+// SimpleName returns the name of a named type without namespace.
+func (s *Schema) SimpleName() string {
+	return s.Name[strings.LastIndex(s.Name, ".")+1:]
+}
+
+func (s *Schema) isNamed() bool {
+	return s.Type == TypeRecord || s.Type == TypeEnum || s.Type == TypeFixed
+}
+
+// FieldByName returns the record field named name and its index.
+func (s *Schema) FieldByName(name string) (*Field, int) {
+	for i, f := range s.Fields {
+		if f.Name == name {
+			return f, i
+		}
+	}
+	return nil, -1
+}
+
+// ParseSchema parses a schema from its JSON form.
+func ParseSchema(b []byte) (*Schema, error) {
+	return make(Names).Parse(b, "")
+}
+
+// MustParseSchema is like ParseSchema but panics on error. It is meant
+// for schemas known at compile time.
+func MustParseSchema(s string) *Schema {
+	schema, err := ParseSchema([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Names holds named types by full name, so that schemas parsed with it,
+// e.g. the types of a protocol, can refer to each other.
+type Names map[string]*Schema
+
+// Parse parses a schema from its JSON form, resolving names relative to
+// namespace and defining named types in n.
+func (n Names) Parse(b []byte, namespace string) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return n.parse(v, namespace)
+}
+
+func (n Names) parse(v interface{}, ns string) (*Schema, error) {
+	switch v := v.(type) {
+	case string:
+		return n.lookup(v, ns)
+	case []interface{}:
+		s := &Schema{Type: TypeUnion}
+		for _, b := range v {
+			branch, err := n.parse(b, ns)
+			if err != nil {
+				return nil, err
+			}
+			if branch.Type == TypeUnion {
+				return nil, fmt.Errorf("union can not contain union")
+			}
+			s.Branches = append(s.Branches, branch)
+		}
+		return s, nil
+	case map[string]interface{}:
+		return n.parseComplex(v, ns)
+	}
+	return nil, fmt.Errorf("invalid schema:%v", v)
+}
+
+func (n Names) lookup(name, ns string) (*Schema, error) {
+	if t := Type(name); isPrimitive(t) {
+		return &Schema{Type: t}, nil
+	}
+	if s, ok := n[fullName(name, ns)]; ok {
+		return s, nil
+	}
+	if s, ok := n[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown type:%s", name)
+}
+
+func fullName(name, ns string) string {
+	if strings.Contains(name, ".") || ns == "" {
+		return name
+	}
+	return ns + "." + name
+}
+
+func (n Names) parseComplex(m map[string]interface{}, ns string) (*Schema, error) {
+	var t string
+	switch v := m["type"].(type) {
+	case string:
+		t = v
+	case nil:
+		return nil, fmt.Errorf("schema without type:%v", m)
+	default:
+		// {"type": {...}} or {"type": [...]} is the inner schema
+		return n.parse(v, ns)
+	}
+
+	s := &Schema{Type: Type(t)}
+	s.Doc, _ = m["doc"].(string)
+	s.LogicalType, _ = m["logicalType"].(string)
+	s.Precision = jsonInt(m["precision"])
+	s.Scale = jsonInt(m["scale"])
+	switch s.Type {
+	case TypeNull, TypeBoolean, TypeInt, TypeLong, TypeFloat, TypeDouble, TypeBytes, TypeString:
+		return s, nil
+	case "error":
+		s.Type = TypeRecord
+		s.IsError = true
+	case TypeRecord, TypeEnum, TypeFixed:
+	case TypeArray:
+		items, err := n.parse(m["items"], ns)
+		if err != nil {
+			return nil, fmt.Errorf("array items:%s", err)
+		}
+		s.Items = items
+		return s, nil
+	case TypeMap:
+		values, err := n.parse(m["values"], ns)
+		if err != nil {
+			return nil, fmt.Errorf("map values:%s", err)
+		}
+		s.Values = values
+		return s, nil
+	default:
+		return n.lookup(t, ns)
+	}
+
+	// named types
+	name, _ := m["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%s without name", t)
+	}
+	if space, ok := m["namespace"].(string); ok && !strings.Contains(name, ".") {
+		ns = space
+	}
+	s.Name = fullName(name, ns)
+	ns = s.Namespace()
+	if _, ok := n[s.Name]; ok {
+		return nil, fmt.Errorf("duplicate type:%s", s.Name)
+	}
+	aliases, _ := m["aliases"].([]interface{})
+	for _, a := range aliases {
+		if a, ok := a.(string); ok {
+			s.Aliases = append(s.Aliases, fullName(a, ns))
+		}
+	}
+	n[s.Name] = s
+
+	switch s.Type {
+	case TypeRecord:
+		fields, ok := m["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %s without fields", s.Name)
+		}
+		for _, f := range fields {
+			field, err := n.parseField(f, ns)
+			if err != nil {
+				return nil, fmt.Errorf("record %s:%s", s.Name, err)
+			}
+			if g, _ := s.FieldByName(field.Name); g != nil {
+				return nil, fmt.Errorf("record %s duplicate field:%s", s.Name, field.Name)
+			}
+			s.Fields = append(s.Fields, field)
+		}
+	case TypeEnum:
+		symbols, ok := m["symbols"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("enum %s without symbols", s.Name)
+		}
+		for _, sym := range symbols {
+			sym, ok := sym.(string)
+			if !ok || enumIndex(s.Symbols, sym) != -1 {
+				return nil, fmt.Errorf("enum %s invalid symbol:%v", s.Name, sym)
+			}
+			s.Symbols = append(s.Symbols, sym)
+		}
+		if def, ok := m["default"].(string); ok {
+			if enumIndex(s.Symbols, def) == -1 {
+				return nil, fmt.Errorf("enum %s unknown default:%s", s.Name, def)
+			}
+			s.EnumDefault = def
+		}
+	case TypeFixed:
+		n, ok := m["size"].(json.Number)
+		size, err := n.Int64()
+		if !ok || err != nil || size < 0 {
+			return nil, fmt.Errorf("fixed %s invalid size:%v", s.Name, m["size"])
+		}
+		s.Size = int(size)
+	}
+	return s, nil
+}
+
+func (n Names) parseField(v interface{}, ns string) (*Field, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid field:%v", v)
+	}
+	f := &Field{}
+	f.Name, _ = m["name"].(string)
+	if f.Name == "" {
+		return nil, fmt.Errorf("field without name")
+	}
+	f.Doc, _ = m["doc"].(string)
+	aliases, _ := m["aliases"].([]interface{})
+	for _, a := range aliases {
+		if a, ok := a.(string); ok {
+			f.Aliases = append(f.Aliases, a)
+		}
+	}
+	t, err := n.parse(m["type"], ns)
+	if err != nil {
+		return nil, fmt.Errorf("field %s:%s", f.Name, err)
+	}
+	f.Type = t
+	f.Default, f.HasDefault = m["default"]
+	return f, nil
+}
+
+func jsonInt(v interface{}) int {
+	if n, ok := v.(json.Number); ok {
+		i, _ := n.Int64()
+		return int(i)
+	}
+	return 0
+}
+
+// String returns the JSON form of s.
+func (s *Schema) String() string {
+	b, err := s.MarshalJSON()
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// MarshalJSON returns the JSON form of s. Named types are defined at
+// their first use and referred to by full name afterwards.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.toJSON(make(map[string]bool)))
+}
+
+func (s *Schema) toJSON(defined map[string]bool) interface{} {
+	if isPrimitive(s.Type) && s.LogicalType == "" {
+		return string(s.Type)
+	}
+	if s.Type == TypeUnion {
+		branches := make([]interface{}, len(s.Branches))
+		for i, b := range s.Branches {
+			branches[i] = b.toJSON(defined)
+		}
+		return branches
+	}
+	if s.isNamed() {
+		if defined[s.Name] {
+			return s.Name
+		}
+		defined[s.Name] = true
+	}
+
+	o := object{{"type", string(s.Type)}}
+	if s.IsError {
+		o[0].value = "error"
+	}
+	if s.isNamed() {
+		o = append(o, member{"name", s.Name})
+		if len(s.Aliases) > 0 {
+			o = append(o, member{"aliases", s.Aliases})
+		}
+	}
+	if s.Doc != "" {
+		o = append(o, member{"doc", s.Doc})
+	}
+	switch s.Type {
+	case TypeRecord:
+		fields := make([]interface{}, len(s.Fields))
+		for i, f := range s.Fields {
+			fo := object{{"name", f.Name}}
+			if len(f.Aliases) > 0 {
+				fo = append(fo, member{"aliases", f.Aliases})
+			}
+			if f.Doc != "" {
+				fo = append(fo, member{"doc", f.Doc})
+			}
+			fo = append(fo, member{"type", f.Type.toJSON(defined)})
+			if f.HasDefault {
+				fo = append(fo, member{"default", f.Default})
+			}
+			fields[i] = fo
+		}
+		o = append(o, member{"fields", fields})
+	case TypeEnum:
+		o = append(o, member{"symbols", s.Symbols})
+		if s.EnumDefault != "" {
+			o = append(o, member{"default", s.EnumDefault})
+		}
+	case TypeArray:
+		o = append(o, member{"items", s.Items.toJSON(defined)})
+	case TypeMap:
+		o = append(o, member{"values", s.Values.toJSON(defined)})
+	case TypeFixed:
+		o = append(o, member{"size", s.Size})
+	}
+	if s.LogicalType != "" {
+		o = append(o, member{"logicalType", s.LogicalType})
+		if s.Precision != 0 {
+			o = append(o, member{"precision", s.Precision})
+		}
+		if s.Scale != 0 {
+			o = append(o, member{"scale", s.Scale})
+		}
+	}
+	return o
+}
+
+// object is a JSON object which keeps the order of its members.
+type object []member
+
+type member struct {
+	key   string
+	value interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,80 @@
+package avro
+
+import (
+	"testing"
+)
+
+func TestParseSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(`{
+                "type": "record",
+                "name": "LinkedList",
+                "namespace": "org.example",
+                "doc": "a list",
+                "fields": [
+                        {"name": "value", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+                        {"name": "next", "type": ["null", "LinkedList"], "default": null},
+                        {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"], "default": "A"}},
+                        {"name": "id", "type": {"type": "fixed", "name": "other.Id", "size": 4}},
+                        {"name": "tags", "type": {"type": "map", "values": {"type": "array", "items": "string"}}}
+                ]
+        }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "org.example.LinkedList" || s.Namespace() != "org.example" || s.SimpleName() != "LinkedList" {
+		t.Error(s.Name)
+	}
+	if next := s.Fields[1]; next.Type.Branches[1] != s || !next.HasDefault || next.Default != nil {
+		t.Error("next should refer to the record itself")
+	}
+	if s.Fields[0].Type.LogicalType != "timestamp-millis" {
+		t.Error(s.Fields[0].Type)
+	}
+	if k := s.Fields[2].Type; k.Name != "org.example.Kind" || k.EnumDefault != "A" {
+		t.Error(k.Name)
+	}
+	if id := s.Fields[3].Type; id.Name != "other.Id" || id.Size != 4 {
+		t.Error(id.Name)
+	}
+	if tags := s.Fields[4].Type; tags.Values.Items.Type != TypeString {
+		t.Error(tags)
+	}
+
+	expect := `{"type":"record","name":"org.example.LinkedList","doc":"a list","fields":[` +
+		`{"name":"value","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+		`{"name":"next","type":["null","org.example.LinkedList"],"default":null},` +
+		`{"name":"kind","type":{"type":"enum","name":"org.example.Kind","symbols":["A","B"],"default":"A"}},` +
+		`{"name":"id","type":{"type":"fixed","name":"other.Id","size":4}},` +
+		`{"name":"tags","type":{"type":"map","values":{"type":"array","items":"string"}}}]}`
+	if s.String() != expect {
+		t.Error(s)
+	}
+	s1, err := ParseSchema([]byte(s.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1.String() != expect {
+		t.Error(s1)
+	}
+}
+
+func TestParseSchemaError(t *testing.T) {
+	for _, s := range []string{
+		`"Unknown"`,
+		`{"type": "record", "fields": []}`,
+		`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "int"}, {"name": "a", "type": "int"}]}`,
+		`{"type": "enum", "name": "E", "symbols": ["A", "A"]}`,
+		`{"type": "enum", "name": "E", "symbols": ["A"], "default": "B"}`,
+		`["int", ["null"]]`,
+		`{"type": "array"}`,
+		`[{"type": "fixed", "name": "F", "size": 1}, {"type": "fixed", "name": "F", "size": 2}]`,
+		`{"type": "fixed", "name": "F"}`,
+		`{"type": "fixed", "name": "F", "size": "4"}`,
+		`{"type": "fixed", "name": "F", "size": 1.5}`,
+		`{"type": "fixed", "name": "F", "size": -1}`,
+	} {
+		if _, err := ParseSchema([]byte(s)); err == nil {
+			t.Error("should fail:", s)
+		}
+	}
+}
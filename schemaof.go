@@ -0,0 +1,281 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var (
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	enumType      = reflect.TypeOf((*Enum)(nil)).Elem()
+	unionType     = reflect.TypeOf(Union{})
+)
+
+// primitiveTypes are the Go types Encoder writes as avro primitives.
+var primitiveTypes = map[reflect.Type]Type{
+	reflect.TypeOf(Null(0)):    TypeNull,
+	reflect.TypeOf(false):      TypeBoolean,
+	reflect.TypeOf(int8(0)):    TypeInt,
+	reflect.TypeOf(int16(0)):   TypeInt,
+	reflect.TypeOf(int32(0)):   TypeInt,
+	reflect.TypeOf(uint8(0)):   TypeInt,
+	reflect.TypeOf(uint16(0)):  TypeInt,
+	reflect.TypeOf(int(0)):     TypeLong,
+	reflect.TypeOf(int64(0)):   TypeLong,
+	reflect.TypeOf(uint(0)):    TypeLong,
+	reflect.TypeOf(uint32(0)):  TypeLong,
+	reflect.TypeOf(uint64(0)):  TypeLong,
+	reflect.TypeOf(float32(0)): TypeFloat,
+	reflect.TypeOf(float64(0)): TypeDouble,
+	reflect.TypeOf([]byte{}):   TypeBytes,
+	reflect.TypeOf(""):         TypeString,
+}
+
+// SchemaOf returns the schema Encoder writes x with. Structs are records
+// named after the Go type, which a blank field can override along with
+// the doc:
+//
+//	type User struct {
+//	        _     struct{} `avro:"com.example.User" avro_doc:"A user"`
+//	        ID    int64    `avro:"id" avro_doc:"The user id"`
+//	        Email *string  `avro:"email" avro_default:"null"`
+//	}
+//
+// The avro tag names a field, avro_doc documents it and avro_default is
+// the JSON of its default value. Enums and fixed are named after the Go
+// type, or Fixed<size> for unnamed byte arrays, and named types without
+// namespace take the namespace of the enclosing record.
+//
+// An avro.Union is a union of the types of its elements, so x must hold
+// them. Types implementing Marshaler have no schema.
+func SchemaOf(x interface{}) (*Schema, error) {
+	x, err := indirect(x)
+	if err != nil {
+		return nil, err
+	}
+	g := &schemaGen{
+		types: make(map[reflect.Type]*Schema),
+		names: make(map[string]reflect.Type),
+	}
+	return g.schemaOf(reflect.ValueOf(x), reflect.TypeOf(x), "")
+}
+
+type schemaGen struct {
+	types map[reflect.Type]*Schema // named types already generated
+	names map[string]reflect.Type
+}
+
+// define records the named type s generated for t.
+func (g *schemaGen) define(t reflect.Type, s *Schema) error {
+	if other, ok := g.names[s.Name]; ok {
+		return fmt.Errorf("%s and %s are both named %s", other, t, s.Name)
+	}
+	g.names[s.Name] = t
+	g.types[t] = s
+	return nil
+}
+
+// schemaOf returns the schema of t. v is a value of type t if known,
+// which is needed for avro.Union.
+func (g *schemaGen) schemaOf(v reflect.Value, t reflect.Type, ns string) (*Schema, error) {
+	if t == nil {
+		return &Schema{Type: TypeNull}, nil
+	}
+	if s, ok := g.types[t]; ok {
+		return s, nil
+	}
+	if pt, ok := primitiveTypes[t]; ok {
+		return &Schema{Type: pt}, nil
+	}
+	// a pointer is a union of null and the element, even to an Enum or a
+	// Marshaler, as Encoder writes it
+	if t.Kind() == reflect.Ptr {
+		if t.Elem() == nullT {
+			return &Schema{Type: TypeNull}, nil
+		}
+		return g.nullableSchema(t, ns, false)
+	}
+	if t.Implements(marshalerType) {
+		return nil, fmt.Errorf("no schema for Marshaler:%s", t)
+	}
+	if t.Implements(enumType) {
+		return g.enumSchema(t, ns)
+	}
+	if t == unionType {
+		return g.unionSchema(v)
+	}
+
+	switch t.Kind() {
+	case reflect.Array:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("element of array must be byte:%s", t)
+		}
+		name := t.Name()
+		if name == "" {
+			name = "Fixed" + strconv.Itoa(t.Len())
+		}
+		s := &Schema{Type: TypeFixed, Name: fullName(name, ns), Size: t.Len()}
+		return s, g.define(t, s)
+	case reflect.Slice:
+		items, err := g.elemSchema(v, t.Elem(), ns)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeArray, Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key must be string:%s", t)
+		}
+		values, err := g.elemSchema(v, t.Elem(), ns)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeMap, Values: values}, nil
+	case reflect.Struct:
+		return g.recordSchema(v, t, ns)
+	case reflect.Interface:
+		if u := lookupUnion(t); u != nil {
+			s := &Schema{Type: TypeUnion}
+			for _, b := range u.branches {
+				if b.Kind() == reflect.Ptr {
+					b = b.Elem()
+				}
+				branch, err := g.schemaOf(reflect.Value{}, b, ns)
+				if err != nil {
+					return nil, err
+				}
+				s.Branches = append(s.Branches, branch)
+			}
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("not supported:%s", t)
+}
+
+// elemSchema returns the schema of the elements of the slice or map v,
+// using the first element to find the branches of an avro.Union.
+func (g *schemaGen) elemSchema(v reflect.Value, t reflect.Type, ns string) (*Schema, error) {
+	var elem reflect.Value
+	if t == unionType && v.IsValid() && v.Len() > 0 {
+		if v.Kind() == reflect.Map {
+			elem = v.MapIndex(v.MapKeys()[0])
+		} else {
+			elem = v.Index(0)
+		}
+	}
+	return g.schemaOf(elem, t, ns)
+}
+
+func (g *schemaGen) nullableSchema(t reflect.Type, ns string, nullSecond bool) (*Schema, error) {
+	elem, err := g.schemaOf(reflect.Value{}, t.Elem(), ns)
+	if err != nil {
+		return nil, err
+	}
+	null := &Schema{Type: TypeNull}
+	if nullSecond {
+		return &Schema{Type: TypeUnion, Branches: []*Schema{elem, null}}, nil
+	}
+	return &Schema{Type: TypeUnion, Branches: []*Schema{null, elem}}, nil
+}
+
+func (g *schemaGen) unionSchema(v reflect.Value) (*Schema, error) {
+	if !v.IsValid() {
+		return nil, fmt.Errorf("schema of avro.Union needs its elements")
+	}
+	u := v.Interface().(Union)
+	if len(u.Elem) == 0 {
+		return nil, fmt.Errorf("schema of avro.Union needs its elements")
+	}
+	s := &Schema{Type: TypeUnion}
+	for _, e := range u.Elem {
+		e, err := indirect(e)
+		if err != nil {
+			return nil, err
+		}
+		branch, err := g.schemaOf(reflect.ValueOf(e), reflect.TypeOf(e), "")
+		if err != nil {
+			return nil, err
+		}
+		s.Branches = append(s.Branches, branch)
+	}
+	return s, nil
+}
+
+func (g *schemaGen) enumSchema(t reflect.Type, ns string) (*Schema, error) {
+	if t.Name() == "" {
+		return nil, fmt.Errorf("enum must be a named type:%s", t)
+	}
+	e := reflect.Zero(t).Interface().(Enum)
+	s := &Schema{
+		Type:    TypeEnum,
+		Name:    fullName(t.Name(), ns),
+		Symbols: e.Symbols(),
+	}
+	if d, ok := e.(EnumDefaulter); ok {
+		s.EnumDefault = d.Default()
+	}
+	return s, g.define(t, s)
+}
+
+func (g *schemaGen) recordSchema(v reflect.Value, t reflect.Type, ns string) (*Schema, error) {
+	s := &Schema{Type: TypeRecord, Name: t.Name()}
+	n := t.NumField()
+	for i := 0; i < n; i++ {
+		f := t.Field(i)
+		if f.Name == "_" {
+			if name, _ := parseTag(f.Tag.Get("avro")); name != "" {
+				s.Name = name
+			}
+			s.Doc = f.Tag.Get("avro_doc")
+		}
+	}
+	if s.Name == "" {
+		return nil, fmt.Errorf("record needs a name:%s", t)
+	}
+	s.Name = fullName(s.Name, ns)
+	if err := g.define(t, s); err != nil {
+		return nil, err
+	}
+
+	ns = s.Namespace()
+	for i := 0; i < n; i++ {
+		f := t.Field(i)
+		// unexported
+		if f.PkgPath != "" {
+			continue
+		}
+		name, opts := parseTag(f.Tag.Get("avro"))
+		if name == "" {
+			name = f.Name
+		}
+		field := &Field{Name: name, Doc: f.Tag.Get("avro_doc")}
+
+		var err error
+		if f.Type.Kind() == reflect.Ptr && f.Type.Elem() != nullT {
+			field.Type, err = g.nullableSchema(f.Type, ns, opts.Contains("nullsecond"))
+		} else {
+			var fv reflect.Value
+			if v.IsValid() {
+				fv = v.Field(i)
+			}
+			field.Type, err = g.schemaOf(fv, f.Type, ns)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %s:%s", f.Name, err)
+		}
+
+		if def, ok := f.Tag.Lookup("avro_default"); ok {
+			dec := json.NewDecoder(bytes.NewReader([]byte(def)))
+			dec.UseNumber()
+			if err := dec.Decode(&field.Default); err != nil {
+				return nil, fmt.Errorf("field %s default:%s", f.Name, err)
+			}
+			field.HasDefault = true
+		}
+		s.Fields = append(s.Fields, field)
+	}
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package avro
+
+import (
+	"reflect"
+	"testing"
+)
+
+type user struct {
+	_       struct{}          `avro:"com.example.User" avro_doc:"A user"`
+	ID      int64             `avro:"id" avro_doc:"The user id"`
+	Age     int32             `avro:"age" avro_default:"0"`
+	Email   *string           `avro:"email" avro_default:"null"`
+	Friend  *user             `avro:"friend,nullsecond"`
+	Suit    suit              `avro:"suit"`
+	Hash    [16]byte          `avro:"hash"`
+	Tags    map[string]string `avro:"tags"`
+	Shapes  []shape           `avro:"shapes"`
+	Union   Union             `avro:"union"`
+	Null    Null
+	private int
+}
+
+func TestSchemaOf(t *testing.T) {
+	s, err := SchemaOf(&user{Union: MakeUnion(0, new(Null), new(float64))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"type":"record","name":"com.example.User","doc":"A user","fields":[` +
+		`{"name":"id","doc":"The user id","type":"long"},` +
+		`{"name":"age","type":"int","default":0},` +
+		`{"name":"email","type":["null","string"],"default":null},` +
+		`{"name":"friend","type":["com.example.User","null"]},` +
+		`{"name":"suit","type":{"type":"enum","name":"com.example.suit","symbols":["SPADES","HEARTS","DIAMONDS","CLUBS"]}},` +
+		`{"name":"hash","type":{"type":"fixed","name":"com.example.Fixed16","size":16}},` +
+		`{"name":"tags","type":{"type":"map","values":"string"}},` +
+		`{"name":"shapes","type":{"type":"array","items":["null",` +
+		`{"type":"record","name":"com.example.circle","fields":[{"name":"Radius","type":"long"}]},` +
+		`{"type":"record","name":"com.example.square","fields":[{"name":"Side","type":"long"}]},"string"]}},` +
+		`{"name":"union","type":["null","double"]},` +
+		`{"name":"Null","type":"null"}]}`
+	if s.String() != expect {
+		t.Error(s)
+	}
+	if _, err := ParseSchema([]byte(s.String())); err != nil {
+		t.Error(err)
+	}
+
+	for _, x := range []interface{}{
+		1, int16(1), uint64(1), "", []byte{}, float32(0), []int{}, map[string]bool{},
+	} {
+		s, err := SchemaOf(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseSchema([]byte(s.String())); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSchemaOfEnumPointers(t *testing.T) {
+	enum := `{"type":"enum","name":"suit","symbols":["SPADES","HEARTS","DIAMONDS","CLUBS"]}`
+	cases := []struct {
+		x      interface{}
+		expect string
+	}{
+		{[]*suit{}, `{"type":"array","items":["null",` + enum + `]}`},
+		{map[string]*suit{}, `{"type":"map","values":["null",` + enum + `]}`},
+	}
+	for _, c := range cases {
+		s, err := SchemaOf(c.x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.String() != c.expect {
+			t.Errorf("%T: %s", c.x, s)
+		}
+		if err := Validate(s, reflect.TypeOf(c.x)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	two := suit(2)
+	for _, in := range []interface{}{[]*suit{nil, &two}, map[string]*suit{"a": nil, "b": &two}} {
+		b, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := reflect.New(reflect.TypeOf(in))
+		if err := Unmarshal(b, out.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out.Elem().Interface(), in) {
+			t.Errorf("%v decoded as %v", in, out.Elem())
+		}
+	}
+}
+
+func TestSchemaOfError(t *testing.T) {
+	for _, x := range []interface{}{
+		point{},
+		Union{},
+		struct{ A int }{},
+		map[int]int{},
+		[]interface{}{},
+		struct {
+			_ struct{} `avro:"R"`
+			A int      `avro_default:"{"`
+		}{},
+	} {
+		if _, err := SchemaOf(x); err == nil {
+			t.Errorf("%T should fail", x)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package avro
+
+import (
+	"fmt"
+	"math"
+)
+
+// Marshaler is implemented by types which encode themselves with the
+// Write methods of the Encoder instead of by reflection. MarshalAvro
+// must be declared on the value receiver and must not call Flush.
+type Marshaler interface {
+	MarshalAvro(e *Encoder) error
+}
+
+// Unmarshaler is implemented by types which decode themselves with the
+// Read methods of the Decoder.
+type Unmarshaler interface {
+	UnmarshalAvro(d *Decoder) error
+}
+
+// WriteValue writes x like Encode but without flushing.
+func (e *Encoder) WriteValue(x interface{}) error {
+	x, err := indirect(x)
+	if err != nil {
+		return err
+	}
+	return e.marshal(x)
+}
+
+func (e *Encoder) WriteBool(v bool) {
+	e.buf = AppendBool(e.buf, v)
+}
+
+func (e *Encoder) WriteInt(v int32) {
+	e.buf = AppendInt(e.buf, v)
+}
+
+func (e *Encoder) WriteLong(v int64) {
+	e.buf = AppendLong(e.buf, v)
+}
+
+func (e *Encoder) WriteFloat(v float32) {
+	e.buf = AppendFloat(e.buf, v)
+}
+
+func (e *Encoder) WriteDouble(v float64) {
+	e.buf = AppendDouble(e.buf, v)
+}
+
+func (e *Encoder) WriteBytes(b []byte) {
+	e.buf = AppendBytes(e.buf, b)
+}
+
+func (e *Encoder) WriteString(s string) {
+	e.buf = AppendString(e.buf, s)
+}
+
+// WriteFixed writes b as is, without length.
+func (e *Encoder) WriteFixed(b []byte) {
+	e.buf = append(e.buf, b...)
+}
+
+// WriteArrayStart starts a block of n items. An array is written as any
+// number of blocks followed by WriteArrayEnd.
+func (e *Encoder) WriteArrayStart(n int) {
+	if n > 0 {
+		e.buf = AppendLong(e.buf, int64(n))
+	}
+}
+
+func (e *Encoder) WriteArrayEnd() {
+	e.buf = AppendLong(e.buf, 0)
+}
+
+// WriteMapStart starts a block of n entries, each written as a string
+// key followed by the value. A map is written as any number of blocks
+// followed by WriteMapEnd.
+func (e *Encoder) WriteMapStart(n int) {
+	e.WriteArrayStart(n)
+}
+
+func (e *Encoder) WriteMapEnd() {
+	e.WriteArrayEnd()
+}
+
+// WriteUnionIndex writes the branch of a union, followed by the value
+// of the branch.
+func (e *Encoder) WriteUnionIndex(idx int) {
+	e.buf = AppendLong(e.buf, int64(idx))
+}
+
+// Flush writes the buffered data to the underlying writer.
+func (e *Encoder) Flush() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+func (d *Decoder) ReadBool() (bool, error) {
+	var v bool
+	err := d.Decode(&v)
+	return v, err
+}
+
+func (d *Decoder) ReadInt() (int32, error) {
+	n, err := d.readInt()
+	return int32(n), err
+}
+
+func (d *Decoder) ReadLong() (int64, error) {
+	return d.readLong()
+}
+
+func (d *Decoder) ReadFloat() (float32, error) {
+	var v float32
+	err := d.Decode(&v)
+	return v, err
+}
+
+func (d *Decoder) ReadDouble() (float64, error) {
+	var v float64
+	err := d.Decode(&v)
+	return v, err
+}
+
+// ReadBytes reads bytes, which alias the input of a byte slice decoder
+// with zero copy enabled.
+func (d *Decoder) ReadBytes() ([]byte, error) {
+	return d.readBytes()
+}
+
+func (d *Decoder) ReadString() (string, error) {
+	var s string
+	err := d.Decode(&s)
+	return s, err
+}
+
+// ReadFixed reads len(b) bytes into b.
+func (d *Decoder) ReadFixed(b []byte) error {
+	return d.readFull(b)
+}
+
+// ReadArrayStart returns the number of items in the first block of an
+// array. Once the items are read, ReadArrayNext returns the number of
+// items in the next block. The array ends when either returns 0.
+func (d *Decoder) ReadArrayStart() (int, error) {
+	return d.readBlockCount()
+}
+
+func (d *Decoder) ReadArrayNext() (int, error) {
+	return d.readBlockCount()
+}
+
+// ReadMapStart and ReadMapNext are like ReadArrayStart and ReadArrayNext,
+// each entry is a string key followed by the value.
+func (d *Decoder) ReadMapStart() (int, error) {
+	return d.readBlockCount()
+}
+
+func (d *Decoder) ReadMapNext() (int, error) {
+	return d.readBlockCount()
+}
+
+func (d *Decoder) ReadUnionIndex() (int, error) {
+	n, err := d.readInt()
+	return int(n), err
+}
+
+// readBlockCount reads the item count of an array or map block, skipping
+// the byte size that follows a negative count.
+func (d *Decoder) readBlockCount() (int, error) {
+	n, err := d.readLong()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		if n == math.MinInt64 {
+			return 0, fmt.Errorf("block count overflows:%d", n)
+		}
+		n = -n
+		if _, err = d.readLong(); err != nil {
+			return 0, err
+		}
+	}
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("block count too large:%d", n)
+	}
+	return int(n), nil
+}
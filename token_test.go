@@ -0,0 +1,153 @@
+package avro
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// point encodes itself as two ints.
+type point struct {
+	X, Y int32
+}
+
+func (p point) MarshalAvro(e *Encoder) error {
+	e.WriteInt(p.X)
+	e.WriteInt(p.Y)
+	return nil
+}
+
+func (p *point) UnmarshalAvro(d *Decoder) (err error) {
+	if p.X, err = d.ReadInt(); err != nil {
+		return err
+	}
+	p.Y, err = d.ReadInt()
+	return err
+}
+
+func TestMarshaler(t *testing.T) {
+	in := []point{{1, 2}, {-1, -2}}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{4, 2, 4, 1, 3, 0}) {
+		t.Fatal(b)
+	}
+	var out []point
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Error(out)
+	}
+}
+
+func TestMarshalerPointer(t *testing.T) {
+	type shape struct {
+		Center *point
+		Corner *point
+	}
+	in := shape{Center: &point{1, 2}}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{2, 2, 4, 0}) {
+		t.Fatal(b)
+	}
+	var out shape
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Error(out)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.WriteBool(true)
+	enc.WriteLong(1 << 40)
+	enc.WriteFloat(1.5)
+	enc.WriteDouble(2.5)
+	enc.WriteBytes([]byte{1})
+	enc.WriteFixed([]byte{2, 3})
+	enc.WriteArrayStart(2)
+	enc.WriteString("a")
+	enc.WriteString("b")
+	enc.WriteArrayStart(1)
+	enc.WriteString("c")
+	enc.WriteArrayEnd()
+	enc.WriteMapStart(1)
+	enc.WriteString("k")
+	enc.WriteUnionIndex(1)
+	enc.WriteInt(7)
+	enc.WriteMapEnd()
+	if buf.Len() != 0 {
+		t.Fatal("should be buffered until Flush")
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(buf)
+	if v, err := dec.ReadBool(); err != nil || !v {
+		t.Error(v, err)
+	}
+	if v, err := dec.ReadLong(); err != nil || v != 1<<40 {
+		t.Error(v, err)
+	}
+	if v, err := dec.ReadFloat(); err != nil || v != 1.5 {
+		t.Error(v, err)
+	}
+	if v, err := dec.ReadDouble(); err != nil || v != 2.5 {
+		t.Error(v, err)
+	}
+	if v, err := dec.ReadBytes(); err != nil || !bytes.Equal(v, []byte{1}) {
+		t.Error(v, err)
+	}
+	fixed := make([]byte, 2)
+	if err := dec.ReadFixed(fixed); err != nil || !bytes.Equal(fixed, []byte{2, 3}) {
+		t.Error(fixed, err)
+	}
+	var items []string
+	n, err := dec.ReadArrayStart()
+	for ; n > 0 && err == nil; n, err = dec.ReadArrayNext() {
+		for i := 0; i < n; i++ {
+			s, _ := dec.ReadString()
+			items = append(items, s)
+		}
+	}
+	if err != nil || !reflect.DeepEqual(items, []string{"a", "b", "c"}) {
+		t.Error(items, err)
+	}
+	if n, err := dec.ReadMapStart(); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if k, err := dec.ReadString(); err != nil || k != "k" {
+		t.Error(k, err)
+	}
+	if idx, err := dec.ReadUnionIndex(); err != nil || idx != 1 {
+		t.Error(idx, err)
+	}
+	if v, err := dec.ReadInt(); err != nil || v != 7 {
+		t.Error(v, err)
+	}
+	if n, err := dec.ReadMapNext(); err != nil || n != 0 {
+		t.Error(n, err)
+	}
+}
+
+func TestNegativeBlockCount(t *testing.T) {
+	// a block of 2 items with its size in bytes
+	b := []byte{3, 4, 2, 4, 0}
+	var out []int
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, []int{1, 2}) {
+		t.Error(out)
+	}
+}
@@ -1,15 +1,44 @@
 package avro
 
+import "strings"
+
 type Union struct {
-        Idx  int
-        Elem []interface{}
+	Idx  int
+	Elem []interface{}
 }
 
 func MakeUnion(idx int, elem ...interface{}) Union {
-        return Union{
-                Idx:  idx,
-                Elem: elem,
-        }
+	return Union{
+		Idx:  idx,
+		Elem: elem,
+	}
 }
 
 type Null int
+
+// tagOptions is the part of an avro struct tag after the field name,
+// e.g. `avro:"name,nullsecond"`.
+type tagOptions string
+
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+func (o tagOptions) Contains(name string) bool {
+	s := string(o)
+	for s != "" {
+		var opt string
+		if i := strings.Index(s, ","); i != -1 {
+			opt, s = s[:i], s[i+1:]
+		} else {
+			opt, s = s, ""
+		}
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package avro
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// typedUnion is an interface type registered as an avro union.
+type typedUnion struct {
+	t        reflect.Type
+	branches []reflect.Type
+}
+
+var (
+	unionMu sync.RWMutex
+	unions  = make(map[reflect.Type]*typedUnion)
+	nullT   = reflect.TypeOf(Null(0))
+)
+
+// RegisterUnion registers the interface type iface points to as a union,
+// so that values of that type select their branch by Go type instead of
+// by index as Union does. The union branches are the types of branches,
+// in schema order, e.g.
+//
+//	type Shape interface{}
+//	avro.RegisterUnion((*Shape)(nil), avro.Null(0), Circle{}, &Square{})
+//
+// A nil interface value is the Null branch. Decoding a union allocates
+// the value of the selected branch and stores it in the interface.
+func RegisterUnion(iface interface{}, branches ...interface{}) {
+	t := reflect.TypeOf(iface)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		panic(fmt.Errorf("RegisterUnion need pointer to interface:%v", t))
+	}
+	t = t.Elem()
+	u := &typedUnion{t: t}
+	for _, b := range branches {
+		bt := reflect.TypeOf(b)
+		if bt == nil {
+			panic(fmt.Errorf("nil branch of union %s, use avro.Null", t))
+		}
+		if bt != nullT && !bt.Implements(t) {
+			panic(fmt.Errorf("%s does not implement %s", bt, t))
+		}
+		if u.index(bt) != -1 {
+			panic(fmt.Errorf("duplicate branch %s of union %s", bt, t))
+		}
+		u.branches = append(u.branches, bt)
+	}
+
+	unionMu.Lock()
+	defer unionMu.Unlock()
+	unions[t] = u
+}
+
+func lookupUnion(t reflect.Type) *typedUnion {
+	unionMu.RLock()
+	defer unionMu.RUnlock()
+	return unions[t]
+}
+
+func (u *typedUnion) index(t reflect.Type) int {
+	for i, b := range u.branches {
+		if b == t {
+			return i
+		}
+	}
+	return -1
+}
+
+// marshalTypedUnion writes the interface value v of the union u.
+func (e *Encoder) marshalTypedUnion(u *typedUnion, v reflect.Value) error {
+	if v.IsNil() {
+		idx := u.index(nullT)
+		if idx == -1 {
+			return fmt.Errorf("union %s has no null branch", u.t)
+		}
+		e.buf = AppendLong(e.buf, int64(idx))
+		return nil
+	}
+	elem := v.Elem()
+	idx := u.index(elem.Type())
+	if idx == -1 {
+		return fmt.Errorf("%s is not a branch of union %s", elem.Type(), u.t)
+	}
+	e.buf = AppendLong(e.buf, int64(idx))
+	if elem.Kind() == reflect.Ptr {
+		if elem.IsNil() {
+			return fmt.Errorf("nil pointer:%s", elem.Type())
+		}
+		elem = elem.Elem()
+	}
+	return e.marshal(elem.Interface())
+}
+
+// decodeTypedUnion reads a value of the union u into the interface v.
+func (d *Decoder) decodeTypedUnion(u *typedUnion, v reflect.Value) error {
+	idx, err := d.readInt()
+	if err != nil {
+		return err
+	}
+	if idx < 0 || idx >= int64(len(u.branches)) {
+		return fmt.Errorf("union index error:%d", idx)
+	}
+	t := u.branches[idx]
+	if t == nullT {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	var elem reflect.Value
+	if t.Kind() == reflect.Ptr {
+		elem = reflect.New(t.Elem())
+		err = d.Decode(elem.Interface())
+	} else {
+		p := reflect.New(t)
+		err = d.Decode(p.Interface())
+		elem = p.Elem()
+	}
+	if err != nil {
+		return err
+	}
+	v.Set(elem)
+	return nil
+}
@@ -0,0 +1,65 @@
+package avro
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type shape interface{}
+
+type circle struct {
+	Radius int
+}
+
+type square struct {
+	Side int
+}
+
+type drawing struct {
+	Shape  shape
+	Shapes []shape
+	Named  map[string]shape
+}
+
+func init() {
+	RegisterUnion((*shape)(nil), Null(0), circle{}, &square{}, "")
+}
+
+func TestTypedUnion(t *testing.T) {
+	in := drawing{
+		Shape:  circle{2},
+		Shapes: []shape{nil, &square{3}, "label"},
+		Named:  map[string]shape{"a": circle{1}},
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []byte{
+		2, 4, // Shape
+		6, 0, 4, 6, 6, 10, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0, // Shapes
+		2, 2, 0x61, 2, 2, 0, // Named
+	}
+	if !bytes.Equal(b, expect) {
+		t.Fatal(b)
+	}
+
+	var out drawing
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Error(out)
+	}
+}
+
+func TestTypedUnionError(t *testing.T) {
+	if _, err := Marshal(drawing{Shape: 1}); err == nil {
+		t.Error("int is not a branch")
+	}
+	var out drawing
+	if err := Unmarshal([]byte{8}, &out); err == nil {
+		t.Error("index 4 is out of range")
+	}
+}
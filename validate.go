@@ -0,0 +1,485 @@
+package avro
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// ValidationError is a mismatch between a schema and a Go type or value.
+// Path locates it, from the outermost type, e.g. User.tags["a"].
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ":" + e.Message
+}
+
+// ValidationErrors lists every mismatch found.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	s := make([]string, len(errs))
+	for i, err := range errs {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Validate checks that Encoder and Decoder map t to schema, i.e. that
+// field counts, field names, types, union branches and fixed sizes line
+// up. It returns nil or the ValidationErrors found. Types implementing
+// Marshaler or Unmarshaler are not checked.
+func Validate(schema *Schema, t reflect.Type) error {
+	v := &typeValidator{seen: make(map[typePair]bool)}
+	v.validate(schema, t, typeName(schema), false)
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type typePair struct {
+	s *Schema
+	t reflect.Type
+}
+
+type typeValidator struct {
+	seen map[typePair]bool
+	errs ValidationErrors
+}
+
+func (v *typeValidator) report(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{path, fmt.Sprintf(format, args...)})
+}
+
+// goTypes are the Go types which read and write each primitive type.
+var goTypes = map[Type][]reflect.Type{
+	TypeNull:    {nullT},
+	TypeBoolean: {reflect.TypeOf(false)},
+	TypeInt: {
+		reflect.TypeOf(int8(0)), reflect.TypeOf(int16(0)), reflect.TypeOf(int32(0)),
+		reflect.TypeOf(uint8(0)), reflect.TypeOf(uint16(0)),
+		reflect.TypeOf(int(0)), reflect.TypeOf(int64(0)),
+		reflect.TypeOf(uint(0)), reflect.TypeOf(uint32(0)), reflect.TypeOf(uint64(0)),
+	},
+	TypeLong: {
+		reflect.TypeOf(int(0)), reflect.TypeOf(int64(0)),
+		reflect.TypeOf(uint(0)), reflect.TypeOf(uint32(0)), reflect.TypeOf(uint64(0)),
+	},
+	TypeFloat:  {reflect.TypeOf(float32(0))},
+	TypeDouble: {reflect.TypeOf(float64(0))},
+	TypeBytes:  {reflect.TypeOf([]byte{}), reflect.TypeOf("")},
+	TypeString: {reflect.TypeOf(""), reflect.TypeOf([]byte{})},
+}
+
+// validate checks s against t. nullSecond is the tag option of the
+// struct field of type t.
+func (v *typeValidator) validate(s *Schema, t reflect.Type, path string, nullSecond bool) {
+	if s.isNamed() {
+		pair := typePair{s, t}
+		if v.seen[pair] {
+			return
+		}
+		v.seen[pair] = true
+	}
+	if t == nullT || t == reflect.PtrTo(nullT) {
+		if s.Type != TypeNull {
+			v.report(path, "schema %s for %s", typeName(s), t)
+		}
+		return
+	}
+	// a pointer is a union of null and the element, even to an Enum or a
+	// Marshaler, as Encoder and Decoder write and read it
+	if t.Kind() == reflect.Ptr {
+		if s.Type != TypeUnion || len(s.Branches) != 2 {
+			v.report(path, "schema %s for pointer %s, need union of null", typeName(s), t)
+			return
+		}
+		null, elem := 0, 1
+		if nullSecond {
+			null, elem = 1, 0
+		}
+		if s.Branches[null].Type != TypeNull {
+			v.report(path, "union branch %d is %s, need null", null, typeName(s.Branches[null]))
+		}
+		v.validate(s.Branches[elem], t.Elem(), path, false)
+		return
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+	if t.Implements(enumType) {
+		v.validateEnum(s, t, path)
+		return
+	}
+	if t == unionType {
+		if s.Type != TypeUnion {
+			v.report(path, "schema %s for avro.Union", typeName(s))
+		}
+		return
+	}
+
+	if types, ok := goTypes[s.Type]; ok {
+		for _, gt := range types {
+			if t == gt {
+				return
+			}
+		}
+		v.report(path, "schema %s for %s", s.Type, t)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		u := lookupUnion(t)
+		if u == nil {
+			break
+		}
+		if s.Type != TypeUnion {
+			v.report(path, "schema %s for union %s", typeName(s), t)
+			return
+		}
+		if len(s.Branches) != len(u.branches) {
+			v.report(path, "union of %d branches for %s of %d", len(s.Branches), t, len(u.branches))
+		}
+		for i := 0; i < len(s.Branches) && i < len(u.branches); i++ {
+			bt := u.branches[i]
+			if bt.Kind() == reflect.Ptr {
+				bt = bt.Elem()
+			}
+			v.validate(s.Branches[i], bt, path+"["+strconv.Itoa(i)+"]", false)
+		}
+		return
+	}
+
+	switch s.Type {
+	case TypeFixed:
+		if t.Kind() != reflect.Array || t.Elem().Kind() != reflect.Uint8 {
+			v.report(path, "fixed %s for %s", s.Name, t)
+		} else if t.Len() != s.Size {
+			v.report(path, "fixed %s of size %d for %s", s.Name, s.Size, t)
+		}
+	case TypeArray:
+		if t.Kind() != reflect.Slice {
+			v.report(path, "array for %s", t)
+			return
+		}
+		v.validate(s.Items, t.Elem(), path+"[]", false)
+	case TypeMap:
+		if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+			v.report(path, "map for %s", t)
+			return
+		}
+		v.validate(s.Values, t.Elem(), path+"[]", false)
+	case TypeRecord:
+		if t.Kind() != reflect.Struct {
+			v.report(path, "record %s for %s", s.Name, t)
+			return
+		}
+		v.validateRecord(s, t, path)
+	default:
+		v.report(path, "schema %s for %s", typeName(s), t)
+	}
+}
+
+func (v *typeValidator) validateEnum(s *Schema, t reflect.Type, path string) {
+	if s.Type != TypeEnum {
+		v.report(path, "schema %s for enum %s", typeName(s), t)
+		return
+	}
+	symbols := reflect.Zero(t).Interface().(Enum).Symbols()
+	if len(symbols) != len(s.Symbols) {
+		v.report(path, "enum %s has %d symbols, %s has %d", s.Name, len(s.Symbols), t, len(symbols))
+		return
+	}
+	for i, sym := range s.Symbols {
+		if symbols[i] != sym {
+			v.report(path, "enum %s symbol %d is %s, %s has %s", s.Name, i, sym, t, symbols[i])
+		}
+	}
+}
+
+func (v *typeValidator) validateRecord(s *Schema, t reflect.Type, path string) {
+	// the exported fields in order, as Encoder writes them
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) != len(s.Fields) {
+		v.report(path, "record %s has %d fields, %s has %d", s.Name, len(s.Fields), t, len(fields))
+	}
+	for i := 0; i < len(fields) && i < len(s.Fields); i++ {
+		f, sf := fields[i], s.Fields[i]
+		name, opts := parseTag(f.Tag.Get("avro"))
+		if name == "" {
+			name = f.Name
+		}
+		fpath := path + "." + sf.Name
+		if name != sf.Name {
+			v.report(fpath, "field %d is %s in %s", i, name, t)
+		}
+		v.validate(sf.Type, f.Type, fpath, opts.Contains("nullsecond"))
+	}
+}
+
+// ValidateDatum checks that Encoder writes x as valid data of schema:
+// integers fit, enum ordinals and symbols exist, fixed have the right
+// size and union values select an existing branch. It returns nil or
+// the ValidationErrors found. Values implementing Marshaler are not
+// checked.
+func ValidateDatum(schema *Schema, x interface{}) error {
+	x, err := indirect(x)
+	if err != nil {
+		return err
+	}
+	v := &datumValidator{}
+	v.validate(schema, reflect.ValueOf(x), typeName(schema), false)
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type datumValidator struct {
+	errs ValidationErrors
+}
+
+func (v *datumValidator) report(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{path, fmt.Sprintf(format, args...)})
+}
+
+// validate checks x against s. nullSecond is the tag option of the struct
+// field holding x.
+func (v *datumValidator) validate(s *Schema, x reflect.Value, path string, nullSecond bool) {
+	if !x.IsValid() {
+		if s.Type != TypeNull {
+			v.report(path, "nil for %s", typeName(s))
+		}
+		return
+	}
+	t := x.Type()
+	// a pointer is a union of null and the element, even to a Marshaler,
+	// as Encoder writes it
+	if t.Kind() == reflect.Ptr && t.Elem() != nullT {
+		if s.Type != TypeUnion {
+			v.report(path, "pointer %s for %s, need union of null", t, typeName(s))
+			return
+		}
+		v.validateUnion(s, x, path, nullSecond)
+		return
+	}
+	if t.Implements(marshalerType) {
+		return
+	}
+	if x.Kind() == reflect.Interface && lookupUnion(t) == nil {
+		v.validate(s, x.Elem(), path, nullSecond)
+		return
+	}
+	if s.Type == TypeUnion {
+		v.validateUnion(s, x, path, nullSecond)
+		return
+	}
+	if t == nullT || t == reflect.PtrTo(nullT) {
+		if s.Type != TypeNull {
+			v.report(path, "null for %s", typeName(s))
+		}
+		return
+	}
+	if t.Implements(enumType) {
+		v.validateEnum(s, x, path)
+		return
+	}
+
+	if types, ok := goTypes[s.Type]; ok {
+		found := false
+		for _, gt := range types {
+			found = found || t == gt
+		}
+		if !found {
+			v.report(path, "%s for %s", t, s.Type)
+			return
+		}
+		switch s.Type {
+		case TypeInt:
+			n, ok := datumInt(x)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				v.report(path, "%v overflows int", x)
+			}
+		case TypeLong:
+			if _, ok := datumInt(x); !ok {
+				v.report(path, "%v overflows long", x)
+			}
+		}
+		return
+	}
+
+	switch s.Type {
+	case TypeFixed:
+		if t.Kind() != reflect.Array || t.Elem().Kind() != reflect.Uint8 {
+			v.report(path, "%s for fixed %s", t, s.Name)
+		} else if x.Len() != s.Size {
+			v.report(path, "%d bytes for fixed %s of size %d", x.Len(), s.Name, s.Size)
+		}
+	case TypeArray:
+		if t.Kind() != reflect.Slice {
+			v.report(path, "%s for array", t)
+			return
+		}
+		for i := 0; i < x.Len(); i++ {
+			v.validate(s.Items, x.Index(i), path+"["+strconv.Itoa(i)+"]", false)
+		}
+	case TypeMap:
+		if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+			v.report(path, "%s for map", t)
+			return
+		}
+		iter := x.MapRange()
+		for iter.Next() {
+			v.validate(s.Values, iter.Value(), path+"["+strconv.Quote(iter.Key().String())+"]", false)
+		}
+	case TypeRecord:
+		if t.Kind() != reflect.Struct {
+			v.report(path, "%s for record %s", t, s.Name)
+			return
+		}
+		var fields []int
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				fields = append(fields, i)
+			}
+		}
+		if len(fields) != len(s.Fields) {
+			v.report(path, "%s has %d fields for record %s of %d", t, len(fields), s.Name, len(s.Fields))
+			return
+		}
+		for i, sf := range s.Fields {
+			_, opts := parseTag(t.Field(fields[i]).Tag.Get("avro"))
+			v.validate(sf.Type, x.Field(fields[i]), path+"."+sf.Name, opts.Contains("nullsecond"))
+		}
+	default:
+		v.report(path, "%s for %s", t, typeName(s))
+	}
+}
+
+// datumInt returns the integer x as int64, or false if it overflows.
+func datumInt(x reflect.Value) (int64, bool) {
+	switch x.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := x.Uint()
+		return int64(u), u <= math.MaxInt64
+	}
+	return x.Int(), true
+}
+
+// validateUnion checks the branch Encoder selects for x exists in s.
+func (v *datumValidator) validateUnion(s *Schema, x reflect.Value, path string, nullSecond bool) {
+	branch := func(idx int) (*Schema, bool) {
+		if idx < 0 || idx >= len(s.Branches) {
+			v.report(path, "union has no branch %d", idx)
+			return nil, false
+		}
+		return s.Branches[idx], true
+	}
+	t := x.Type()
+	switch {
+	case t == unionType:
+		u := x.Interface().(Union)
+		if u.Idx < 0 || u.Idx >= len(u.Elem) {
+			v.report(path, "avro.Union index %d of %d elements", u.Idx, len(u.Elem))
+			return
+		}
+		b, ok := branch(u.Idx)
+		if !ok {
+			return
+		}
+		elem, err := indirect(u.Elem[u.Idx])
+		if err != nil {
+			v.report(path, "%s", err)
+			return
+		}
+		v.validate(b, reflect.ValueOf(elem), path, false)
+	case t.Kind() == reflect.Ptr && t.Elem() != nullT:
+		null, elem := 0, 1
+		if nullSecond {
+			null, elem = 1, 0
+		}
+		if x.IsNil() {
+			if b, ok := branch(null); ok && b.Type != TypeNull {
+				v.report(path, "nil for union branch %d of %s", null, typeName(b))
+			}
+			return
+		}
+		if b, ok := branch(elem); ok {
+			v.validate(b, x.Elem(), path, false)
+		}
+	case t.Kind() == reflect.Interface && lookupUnion(t) != nil:
+		u := lookupUnion(t)
+		if x.IsNil() {
+			idx := u.index(nullT)
+			if idx == -1 {
+				v.report(path, "nil is not a branch of union %s", t)
+			} else if b, ok := branch(idx); ok && b.Type != TypeNull {
+				v.report(path, "nil for union branch %d of %s", idx, typeName(b))
+			}
+			return
+		}
+		elem := x.Elem()
+		idx := u.index(elem.Type())
+		if idx == -1 {
+			v.report(path, "%s is not a branch of union %s", elem.Type(), t)
+			return
+		}
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				v.report(path, "nil pointer:%s", elem.Type())
+				return
+			}
+			elem = elem.Elem()
+		}
+		if b, ok := branch(idx); ok {
+			v.validate(b, elem, path, false)
+		}
+	default:
+		v.report(path, "%s does not select a union branch", t)
+	}
+}
+
+func (v *datumValidator) validateEnum(s *Schema, x reflect.Value, path string) {
+	if s.Type != TypeEnum {
+		v.report(path, "enum %s for %s", x.Type(), typeName(s))
+		return
+	}
+	symbols := x.Interface().(Enum).Symbols()
+	var sym string
+	switch x.Kind() {
+	case reflect.String:
+		sym = x.String()
+	default:
+		n, ok := datumInt(x)
+		if !ok || n < 0 || n >= int64(len(symbols)) {
+			v.report(path, "enum ordinal %v out of range", x)
+			return
+		}
+		sym = symbols[n]
+	}
+	idx := enumIndex(symbols, sym)
+	if idx == -1 {
+		v.report(path, "enum symbol %s unknown", sym)
+		return
+	}
+	if idx >= len(s.Symbols) || s.Symbols[idx] != sym {
+		v.report(path, "enum symbol %s is not symbol %d of %s", sym, idx, s.Name)
+	}
+}
@@ -0,0 +1,219 @@
+package avro
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	s, err := SchemaOf(user{Union: MakeUnion(0, new(Null), new(float64))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(s, reflect.TypeOf(user{})); err != nil {
+		t.Error(err)
+	}
+	if err := Validate(MustParseSchema(`"long"`), reflect.TypeOf(int32(0))); err == nil {
+		t.Error("int32 can not hold a long")
+	}
+	if err := Validate(MustParseSchema(`"int"`), reflect.TypeOf(int64(0))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateMismatch(t *testing.T) {
+	s := MustParseSchema(`{"type": "record", "name": "R", "fields": [
+                {"name": "Int", "type": "string"},
+                {"name": "Nil", "type": "null"},
+                {"name": "fixed", "type": {"type": "fixed", "name": "F", "size": 4}},
+                {"name": "String", "type": ["null", "string"]},
+                {"name": "Extra", "type": "int"}
+        ]}`)
+	err := Validate(s, reflect.TypeOf(record{}))
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"R:record R has 5 fields, avro.record has 4",
+		"R.Int:schema string for int",
+		"R.fixed:field 2 is Fixed in avro.record",
+		"R.fixed:fixed F of size 4 for [3]uint8",
+		"R.String:schema union for string",
+	}
+	if len(errs) != len(expect) {
+		t.Fatal(errs)
+	}
+	for i, e := range errs {
+		if e.Error() != expect[i] {
+			t.Error(e)
+		}
+	}
+
+	s = MustParseSchema(`{"type": "array", "items": ["null", "int", "long", "string"]}`)
+	if err := Validate(s, reflect.TypeOf([]shape{})); err == nil {
+		t.Error("union branches do not match")
+	}
+	s = MustParseSchema(`{"type": "enum", "name": "suit", "symbols": ["SPADES", "HEARTS", "CLUBS", "DIAMONDS"]}`)
+	if err := Validate(s, reflect.TypeOf(suit(0))); err == nil {
+		t.Error("enum symbols do not match")
+	}
+	s = MustParseSchema(`{"type": "record", "name": "nullable", "fields": [
+                {"name": "Int", "type": ["null", "long"]},
+                {"name": "String", "type": ["null", "string"]},
+                {"name": "Rec", "type": ["null", "string"]},
+                {"name": "Ints", "type": {"type": "array", "items": ["null", "long"]}}
+        ]}`)
+	err = Validate(s, reflect.TypeOf(nullable{}))
+	if errs, ok := err.(ValidationErrors); !ok || len(errs) != 3 {
+		t.Error(err)
+	}
+}
+
+func TestValidatePointers(t *testing.T) {
+	type trick struct {
+		Trump *suit
+	}
+	s, err := SchemaOf(trick{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(s, reflect.TypeOf(trick{})); err != nil {
+		t.Error(err)
+	}
+
+	type hand struct {
+		Suit   *suit
+		Center *point
+	}
+	s = MustParseSchema(`{"type": "record", "name": "hand", "fields": [
+                {"name": "Suit", "type": ["null", {"type": "enum", "name": "suit", "symbols": ["SPADES", "HEARTS", "DIAMONDS", "CLUBS"]}]},
+                {"name": "Center", "type": ["null", "bytes"]}
+        ]}`)
+	if err := Validate(s, reflect.TypeOf(hand{})); err != nil {
+		t.Error(err)
+	}
+	s = MustParseSchema(`{"type": "record", "name": "hand", "fields": [
+                {"name": "Suit", "type": {"type": "enum", "name": "suit", "symbols": ["SPADES", "HEARTS", "DIAMONDS", "CLUBS"]}},
+                {"name": "Center", "type": "bytes"}
+        ]}`)
+	err = Validate(s, reflect.TypeOf(hand{}))
+	if errs, ok := err.(ValidationErrors); !ok || len(errs) != 2 {
+		t.Error(err)
+	}
+}
+
+func TestValidateDatum(t *testing.T) {
+	in := user{Union: MakeUnion(1, new(Null), new(float64)), Tags: map[string]string{"a": "b"}}
+	s, err := SchemaOf(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateDatum(s, in); err != nil {
+		t.Error(err)
+	}
+
+	in.Suit = 4
+	in.Union.Idx = 2
+	in.Shapes = []shape{circle{}, 1}
+	err = ValidateDatum(s, &in)
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"com.example.User.suit:enum ordinal 4 out of range",
+		"com.example.User.shapes[1]:int is not a branch of union avro.shape",
+		"com.example.User.union:avro.Union index 2 of 2 elements",
+	}
+	if len(errs) != len(expect) {
+		t.Fatal(errs)
+	}
+	for i, e := range errs {
+		if e.Error() != expect[i] {
+			t.Error(e)
+		}
+	}
+
+	s = MustParseSchema(`{"type": "map", "values": "int"}`)
+	err = ValidateDatum(s, map[string]int64{"a": 1 << 40})
+	if err == nil || err.Error() != `map["a"]:1099511627776 overflows int` {
+		t.Error(err)
+	}
+	s = MustParseSchema(`{"type": "fixed", "name": "F", "size": 4}`)
+	if err := ValidateDatum(s, [3]byte{}); err == nil {
+		t.Error("fixed of wrong size")
+	}
+	s = MustParseSchema(`["null", "string"]`)
+	if err := ValidateDatum(s, "a"); err == nil {
+		t.Error("string does not select a branch")
+	}
+}
+
+func TestValidateDatumMarshalerPointer(t *testing.T) {
+	type shape struct {
+		Center *point
+	}
+	s := MustParseSchema(`{"type": "record", "name": "shape", "fields": [{"name": "Center", "type": "long"}]}`)
+	for _, x := range []shape{{}, {&point{1, 2}}} {
+		err := ValidateDatum(s, x)
+		if err == nil || err.Error() != "shape.Center:pointer *avro.point for long, need union of null" {
+			t.Error(err)
+		}
+	}
+	s = MustParseSchema(`{"type": "record", "name": "shape", "fields": [{"name": "Center", "type": ["null", "bytes"]}]}`)
+	for _, x := range []shape{{}, {&point{1, 2}}} {
+		if err := ValidateDatum(s, x); err != nil {
+			t.Error(err)
+		}
+	}
+	s = MustParseSchema(`{"type": "record", "name": "shape", "fields": [{"name": "Center", "type": ["bytes", "null"]}]}`)
+	if err := ValidateDatum(s, shape{}); err == nil {
+		t.Error("nil for bytes")
+	}
+}
+
+// figure is a union without null.
+type figure interface{}
+
+func init() {
+	RegisterUnion((*figure)(nil), circle{}, &square{})
+}
+
+func TestValidateUnionNil(t *testing.T) {
+	type frame struct {
+		Figure figure
+	}
+	s, err := SchemaOf(frame{Figure: circle{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateDatum(s, frame{Figure: circle{}}); err != nil {
+		t.Error(err)
+	}
+	err = ValidateDatum(s, &frame{})
+	if err == nil || !strings.HasSuffix(err.Error(), "nil is not a branch of union avro.figure") {
+		t.Error(err)
+	}
+	err = ValidateDatum(s, frame{Figure: (*square)(nil)})
+	if err == nil || !strings.HasSuffix(err.Error(), "nil pointer:*avro.square") {
+		t.Error(err)
+	}
+}
+
+func TestEncoderSetSchema(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.SetSchema(MustParseSchema(`{"type": "enum", "name": "suit", "symbols": ["SPADES", "HEARTS", "DIAMONDS", "CLUBS"]}`))
+	if err := enc.Encode(suit(1)); err != nil {
+		t.Error(err)
+	}
+	if err := enc.Encode(color("RED")); err == nil {
+		t.Error("color is not a suit")
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{2}) {
+		t.Error(buf.Bytes())
+	}
+}